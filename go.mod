@@ -3,16 +3,17 @@ module github.com/RSOam/reservations-service
 go 1.17
 
 require (
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-kit/kit v0.12.0
 	github.com/go-kit/log v0.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/hashicorp/consul/api v1.10.1
+	github.com/lib/pq v1.10.9
 	go.mongodb.org/mongo-driver v1.8.1
 )
 
 require (
 	github.com/armon/go-metrics v0.3.9 // indirect
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
 	github.com/fatih/color v1.12.0 // indirect
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
@@ -0,0 +1,223 @@
+package reservations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type contextKey int
+
+const (
+	contextKeyPrincipal contextKey = iota
+	contextKeyIdempotencyKey
+)
+
+// Principal is the authenticated caller of a request. Roles and, for
+// operators, the chargers they manage come from the token claims.
+type Principal struct {
+	UserID   string
+	Roles    []Role
+	Chargers []string
+}
+
+// DefaultAlgorithms are the signing algorithms accepted unless configured
+// otherwise.
+var DefaultAlgorithms = []string{"HS256", "RS256", "ES256"}
+
+// TokenConfig describes which tokens a TokenAuthenticator accepts. Empty
+// Audience and Issuer are not checked; Leeway absorbs clock skew when
+// checking exp and nbf.
+type TokenConfig struct {
+	Algorithms []string
+	Audience   string
+	Issuer     string
+	Leeway     time.Duration
+}
+
+// TokenAuthenticator resolves callers from signed bearer tokens.
+type TokenAuthenticator struct {
+	keys   KeySource
+	config TokenConfig
+}
+
+func NewTokenAuthenticator(keys KeySource, config TokenConfig) *TokenAuthenticator {
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = DefaultAlgorithms
+	}
+	return &TokenAuthenticator{
+		keys:   keys,
+		config: config,
+	}
+}
+
+// Authenticate resolves the caller from an "Authorization: Bearer <jwt>"
+// header value.
+func (a *TokenAuthenticator) Authenticate(header string) (Principal, error) {
+	scheme, token, ok := cutBearer(header)
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, ErrUnauthorized
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	return principalFromClaims(claims)
+}
+
+// Verify checks the signature, algorithm, expiry, audience and issuer of
+// token and returns its claims. All failures wrap ErrUnauthorized.
+func (a *TokenAuthenticator) Verify(token string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{
+		ValidMethods: a.config.Algorithms,
+		// Time based claims are checked below, with leeway and exp required.
+		SkipClaimsValidation: true,
+	}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := a.keys.Key(kid, t.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		if !keyMatchesAlg(key, t.Method.Alg()) {
+			return nil, errUnknownKey
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, tokenError(err.Error())
+	}
+	if err := a.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *TokenAuthenticator) validateClaims(claims jwt.MapClaims, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return tokenError("token has no expiry")
+	}
+	if now.Add(-a.config.Leeway).After(time.Unix(int64(exp), 0)) {
+		return tokenError("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return tokenError("token is not valid yet")
+	}
+	if a.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.config.Issuer {
+			return tokenError("unexpected issuer")
+		}
+	}
+	if a.config.Audience != "" {
+		audiences := claimStrings(claims, "aud")
+		if aud, ok := claims["aud"].(string); ok {
+			audiences = append(audiences, aud)
+		}
+		found := false
+		for _, aud := range audiences {
+			found = found || aud == a.config.Audience
+		}
+		if !found {
+			return tokenError("unexpected audience")
+		}
+	}
+	return nil
+}
+
+func tokenError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrUnauthorized, reason)
+}
+
+func cutBearer(header string) (string, string, bool) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// StaticSecret is a KeySource for HMAC tokens signed with a fixed secret.
+type StaticSecret []byte
+
+func (ss StaticSecret) Key(kid string, alg string) (interface{}, error) {
+	if !isHMAC(alg) || len(ss) == 0 {
+		return nil, errUnknownKey
+	}
+	return []byte(ss), nil
+}
+
+// principalFromClaims reads the caller from the user_id, roles (or role) and
+// chargers claims. Callers without a role claim are regular users.
+func principalFromClaims(claims jwt.MapClaims) (Principal, error) {
+	principal := Principal{}
+	userID, ok := claims["user_id"].(string)
+	if !ok || userID == "" {
+		return principal, tokenError("token has no user_id")
+	}
+	principal.UserID = userID
+	for _, role := range claimStrings(claims, "roles") {
+		principal.Roles = append(principal.Roles, Role(role))
+	}
+	if role, ok := claims["role"].(string); ok {
+		principal.Roles = append(principal.Roles, Role(role))
+	}
+	if len(principal.Roles) == 0 {
+		principal.Roles = []Role{RoleUser}
+	}
+	principal.Chargers = claimStrings(claims, "chargers")
+	return principal, nil
+}
+
+// claimStrings reads a claim holding a list of strings.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+	strs := []string{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+// MakeAuthMiddleware rejects requests without a valid bearer token with
+// ErrUnauthorized and puts the caller into the context of the rest. It wraps
+// the HTTP handlers, so a request is authenticated before its body is
+// decoded or validated.
+func MakeAuthMiddleware(auth *TokenAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := auth.Authenticate(r.Header.Get("Authorization"))
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContextWithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func NewContextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKeyPrincipal, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKeyPrincipal).(Principal)
+	return principal, ok
+}
+
+// principalFrom returns the caller of ctx, or ErrUnauthorized when the
+// request was not authenticated.
+func principalFrom(ctx context.Context) (Principal, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.UserID == "" {
+		return principal, ErrUnauthorized
+	}
+	return principal, nil
+}
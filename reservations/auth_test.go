@@ -0,0 +1,238 @@
+package reservations
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks string
+}
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// newTestKeys generates an RSA and an EC key and writes their public halves
+// to a JWKS file.
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+		},
+	}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey, jwks: path}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id": "61c9c4e2f1a2b3c4d5e6f708",
+		"roles":   []string{"operator"},
+		"aud":     []string{"reservations"},
+		"iss":     "https://auth.example",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestTokenAuthenticatorJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	auth := NewTokenAuthenticator(NewJWKSFile(keys.jwks, time.Minute), TokenConfig{
+		Audience: "reservations",
+		Issuer:   "https://auth.example",
+	})
+
+	for name, token := range map[string]string{
+		"RS256": sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims()),
+		"ES256": sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, validClaims()),
+	} {
+		principal, err := auth.Authenticate("Bearer " + token)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if principal.UserID != "61c9c4e2f1a2b3c4d5e6f708" || !principal.HasRole(RoleOperator) {
+			t.Fatalf("%s: unexpected principal %+v", name, principal)
+		}
+	}
+}
+
+func TestJWKSServesKnownKeysWhileReloading(t *testing.T) {
+	keys := newTestKeys(t)
+	data, err := os.ReadFile(keys.jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads := make(chan chan struct{}, 1)
+	jwks := &JWKS{
+		load: func() ([]byte, error) {
+			select {
+			case release := <-loads:
+				<-release
+			default:
+			}
+			return data, nil
+		},
+		// Every lookup finds the keys stale.
+		refresh: -time.Second,
+	}
+	if _, err := jwks.Key("rsa-1", "RS256"); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	loads <- release
+	reloaded := make(chan error)
+	go func() {
+		_, err := jwks.Key("rsa-1", "RS256")
+		reloaded <- err
+	}()
+	waitFor(t, "reload to start", func() bool { return len(loads) == 0 })
+
+	looked := make(chan error)
+	go func() {
+		_, err := jwks.Key("ec-1", "ES256")
+		looked <- err
+	}()
+	select {
+	case err := <-looked:
+		if err != nil {
+			t.Errorf("known key during reload: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("known key lookup waited for the reload")
+	}
+	close(release)
+	if err := <-reloaded; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenAuthenticatorRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	auth := NewTokenAuthenticator(KeySources{NewJWKSFile(keys.jwks, time.Minute), StaticSecret("secret")}, TokenConfig{
+		Audience: "reservations",
+		Issuer:   "https://auth.example",
+	})
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"expired":                         sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+		"no expiry":                       sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { delete(c, "exp") })),
+		"wrong audience":                  sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["aud"] = "billing" })),
+		"wrong issuer":                    sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example" })),
+		"missing user_id":                 sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { delete(c, "user_id") })),
+		"numeric user_id":                 sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["user_id"] = 42 })),
+		"unknown kid":                     sign(t, jwt.SigningMethodRS256, "rsa-2", keys.rsa, validClaims()),
+		"wrong key":                       sign(t, jwt.SigningMethodES256, "rsa-1", keys.ec, validClaims()),
+		"HS256 with public key as secret": sign(t, jwt.SigningMethodHS256, "rsa-1", rsaPublic, validClaims()),
+		"disallowed algorithm":            sign(t, jwt.SigningMethodHS512, "", []byte("secret"), validClaims()),
+	}
+	for name, token := range cases {
+		_, err := auth.Authenticate("Bearer " + token)
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s: want ErrUnauthorized, got %v", name, err)
+		}
+	}
+}
+
+func TestAuthMiddlewareHTTP(t *testing.T) {
+	auth := NewTokenAuthenticator(StaticSecret("secret"), TokenConfig{})
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	var seen []Principal
+	capture := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal, _ := PrincipalFromContext(ctx)
+			seen = append(seen, principal)
+			return next(ctx, request)
+		}
+	}
+	handler := NewHttpServer(context.Background(), MakeEndpoints(srv).Wrap(capture), NewHealth(), MakeAuthMiddleware(auth))
+	do := func(method string, authorization string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/reservations/"+primitive.NewObjectID().Hex(), strings.NewReader(body))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for name, authorization := range map[string]string{
+		"missing":   "",
+		"malformed": "Bearer",
+		"basic":     "Basic dXNlcjpwYXNz",
+		"invalid":   "Bearer " + sign(t, jwt.SigningMethodHS256, "", []byte("other"), validClaims()),
+	} {
+		w := do(http.MethodGet, authorization, "")
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s token: got %d, WWW-Authenticate %q", name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+	if len(seen) != 0 {
+		t.Fatalf("unauthenticated requests reached the endpoint: %+v", seen)
+	}
+
+	w := do(http.MethodGet, "Bearer "+sign(t, jwt.SigningMethodHS256, "", []byte("secret"), validClaims()), "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("valid token: got %d %s", w.Code, w.Body)
+	}
+	if len(seen) != 1 || seen[0].UserID != "61c9c4e2f1a2b3c4d5e6f708" || !seen[0].HasRole(RoleOperator) {
+		t.Errorf("endpoint saw principals %+v", seen)
+	}
+}
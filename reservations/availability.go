@@ -0,0 +1,69 @@
+package reservations
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxAvailabilityRange caps how far an availability query may reach.
+const MaxAvailabilityRange = 31 * 24 * time.Hour
+
+// activeStates are the states in which a reservation occupies its charger.
+var activeStates = []ReservationState{StatePending, StateConfirmed, StateCheckedIn}
+
+type Interval struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type Availability struct {
+	ChargerID string     `json:"chargerID"`
+	From      time.Time  `json:"from"`
+	To        time.Time  `json:"to"`
+	Free      []Interval `json:"free"`
+	Busy      []Interval `json:"busy"`
+}
+
+// computeAvailability splits [from, to) into busy intervals, taken from the
+// reservations clipped to the range and merged, and the free gaps between
+// them. Gaps shorter than slot are left out so every free interval can hold
+// at least one booking.
+func computeAvailability(from time.Time, to time.Time, slot time.Duration, reservations []Reservation) ([]Interval, []Interval) {
+	busy := []Interval{}
+	for _, reservation := range reservations {
+		if !overlaps(from, to, reservation.From, reservation.To) {
+			continue
+		}
+		interval := Interval{From: reservation.From, To: reservation.To}
+		if interval.From.Before(from) {
+			interval.From = from
+		}
+		if interval.To.After(to) {
+			interval.To = to
+		}
+		busy = append(busy, interval)
+	}
+	sort.Slice(busy, func(i, j int) bool { return busy[i].From.Before(busy[j].From) })
+
+	merged := []Interval{}
+	for _, interval := range busy {
+		last := len(merged) - 1
+		if last >= 0 && !interval.From.After(merged[last].To) {
+			if interval.To.After(merged[last].To) {
+				merged[last].To = interval.To
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+
+	free := []Interval{}
+	cursor := from
+	for _, interval := range append(merged, Interval{From: to, To: to}) {
+		if interval.From.Sub(cursor) >= slot && interval.From.After(cursor) {
+			free = append(free, Interval{From: cursor, To: interval.From})
+		}
+		cursor = interval.To
+	}
+	return free, merged
+}
@@ -0,0 +1,39 @@
+package reservations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeAvailability(t *testing.T) {
+	base := time.Date(2022, 1, 10, 8, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	reservations := []Reservation{
+		{From: at(-30), To: at(30)},
+		{From: at(60), To: at(90)},
+		{From: at(80), To: at(120)},
+		{From: at(130), To: at(150)},
+	}
+	free, busy := computeAvailability(at(0), at(240), 15*time.Minute, reservations)
+
+	wantBusy := []Interval{{at(0), at(30)}, {at(60), at(120)}, {at(130), at(150)}}
+	wantFree := []Interval{{at(30), at(60)}, {at(150), at(240)}}
+	if !equalIntervals(busy, wantBusy) {
+		t.Errorf("busy = %v, want %v", busy, wantBusy)
+	}
+	if !equalIntervals(free, wantFree) {
+		t.Errorf("free = %v, want %v", free, wantFree)
+	}
+}
+
+func equalIntervals(a, b []Interval) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].From.Equal(b[i].From) || !a[i].To.Equal(b[i].To) {
+			return false
+		}
+	}
+	return true
+}
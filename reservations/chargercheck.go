@@ -0,0 +1,105 @@
+package reservations
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChargerCheckMode sets how strictly reservations are checked against the
+// chargers service.
+type ChargerCheckMode string
+
+const (
+	// ChargerCheckStrict rejects a reservation when the charger is unknown
+	// or the chargers service cannot be asked.
+	ChargerCheckStrict ChargerCheckMode = "strict"
+	// ChargerCheckLenient rejects unknown chargers, but while the chargers
+	// service is down it accepts chargers from the last list it returned,
+	// and any charger if it never answered.
+	ChargerCheckLenient ChargerCheckMode = "cached-lenient"
+	// ChargerCheckOff accepts any charger ID.
+	ChargerCheckOff ChargerCheckMode = "off"
+)
+
+var ErrChargerNotFound = kindError(ErrNotFound, "charger not found")
+
+// ParseChargerCheckMode parses the value of the charger-check setting.
+func ParseChargerCheckMode(s string) (ChargerCheckMode, error) {
+	switch mode := ChargerCheckMode(s); mode {
+	case ChargerCheckStrict, ChargerCheckLenient, ChargerCheckOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown charger check mode %q", s)
+}
+
+// ChargerChecker confirms that the charger of a new or moved reservation
+// exists. It relies on the ChargersClient cache, so most checks do not call
+// the chargers service.
+type ChargerChecker struct {
+	client ChargersClient
+	mode   ChargerCheckMode
+	logger log.Logger
+
+	mu         sync.Mutex
+	generation uint64
+	known      map[primitive.ObjectID]bool
+}
+
+// NewChargerChecker creates a checker for mode.
+func NewChargerChecker(client ChargersClient, mode ChargerCheckMode, logger log.Logger) *ChargerChecker {
+	return &ChargerChecker{
+		client: client,
+		mode:   mode,
+		logger: log.With(logger, "component", "chargerChecker"),
+	}
+}
+
+// Check returns ErrChargerNotFound if chargerID is not a known charger. A
+// nil checker accepts every charger.
+func (c *ChargerChecker) Check(ctx context.Context, chargerID primitive.ObjectID) error {
+	if c == nil || c.mode == ChargerCheckOff {
+		return nil
+	}
+	// Read before fetching: if a newer list slips in between, the set is
+	// only rebuilt once more than needed.
+	generation := c.client.Generation()
+	chargers, err := c.client.Chargers(ctx)
+	if err != nil {
+		if c.mode == ChargerCheckStrict || ctx.Err() != nil {
+			return err
+		}
+		c.mu.Lock()
+		known, ok := c.known, c.known != nil
+		c.mu.Unlock()
+		level.Warn(c.logger).Log("msg", "chargers service unavailable, checking against the last list", "err", err)
+		if !ok || known[chargerID] {
+			return nil
+		}
+		return ErrChargerNotFound
+	}
+	if !c.lookup(generation, chargers)[chargerID] {
+		return ErrChargerNotFound
+	}
+	return nil
+}
+
+// lookup returns the IDs in chargers, rebuilding the set only when the
+// client has fetched a new list since the last call.
+func (c *ChargerChecker) lookup(generation uint64, chargers []Charger) map[primitive.ObjectID]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.known != nil && c.generation == generation {
+		return c.known
+	}
+	known := make(map[primitive.ObjectID]bool, len(chargers))
+	for _, charger := range chargers {
+		known[charger.ID] = true
+	}
+	c.generation, c.known = generation, known
+	return known
+}
@@ -0,0 +1,118 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stubChargersClient returns a fixed list, or err while it is set. Tests
+// bump generation when they change the list.
+type stubChargersClient struct {
+	chargers   []Charger
+	err        error
+	calls      int
+	generation uint64
+}
+
+func (c *stubChargersClient) Generation() uint64 {
+	return c.generation
+}
+
+func (c *stubChargersClient) Chargers(ctx context.Context) ([]Charger, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.chargers, nil
+}
+
+func TestChargerChecker(t *testing.T) {
+	known := primitive.NewObjectID()
+	unknown := primitive.NewObjectID()
+	down := unavailable(errors.New("connection refused"))
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		mode ChargerCheckMode
+		// Results for the known and unknown charger while the chargers
+		// service answers, after it went down, and when it never answered.
+		up, degraded, never [2]error
+	}{
+		{ChargerCheckStrict, [2]error{nil, ErrChargerNotFound}, [2]error{down, down}, [2]error{down, down}},
+		{ChargerCheckLenient, [2]error{nil, ErrChargerNotFound}, [2]error{nil, ErrChargerNotFound}, [2]error{nil, nil}},
+		{ChargerCheckOff, [2]error{nil, nil}, [2]error{nil, nil}, [2]error{nil, nil}},
+	} {
+		check := func(stage string, checker *ChargerChecker, want [2]error) {
+			for i, id := range []primitive.ObjectID{known, unknown} {
+				if err := checker.Check(ctx, id); !errors.Is(err, want[i]) {
+					t.Errorf("%s %s charger %d: got %v, want %v", tc.mode, stage, i, err, want[i])
+				}
+			}
+		}
+		client := &stubChargersClient{chargers: []Charger{{ID: known}}}
+		checker := NewChargerChecker(client, tc.mode, log.NewNopLogger())
+		check("up", checker, tc.up)
+		client.err = down
+		check("degraded", checker, tc.degraded)
+
+		never := NewChargerChecker(&stubChargersClient{err: down}, tc.mode, log.NewNopLogger())
+		check("never", never, tc.never)
+	}
+
+	// The known set is rebuilt only when the client fetched a new list.
+	client := &stubChargersClient{chargers: []Charger{{ID: known}}}
+	checker := NewChargerChecker(client, ChargerCheckStrict, log.NewNopLogger())
+	checker.Check(ctx, known)
+	client.chargers = []Charger{{ID: known}, {ID: unknown}}
+	if err := checker.Check(ctx, unknown); !errors.Is(err, ErrChargerNotFound) {
+		t.Errorf("set rebuilt without a new generation: %v", err)
+	}
+	client.generation++
+	if err := checker.Check(ctx, unknown); err != nil {
+		t.Errorf("set not rebuilt for a new generation: %v", err)
+	}
+
+	if err := (*ChargerChecker)(nil).Check(ctx, unknown); err != nil {
+		t.Errorf("nil checker: %v", err)
+	}
+	if !errors.Is(ErrChargerNotFound, ErrNotFound) {
+		t.Error("ErrChargerNotFound should be a not-found error")
+	}
+	if _, err := ParseChargerCheckMode("sometimes"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestServiceRejectsUnknownCharger(t *testing.T) {
+	known := primitive.NewObjectID()
+	client := &stubChargersClient{chargers: []Charger{{ID: known}}}
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{},
+		NewChargerChecker(client, ChargerCheckStrict, log.NewNopLogger()))
+	ctx := NewContextWithPrincipal(context.Background(), Principal{UserID: primitive.NewObjectID().Hex()})
+	from := time.Now().Add(time.Hour).Truncate(time.Minute)
+
+	if _, err := srv.CreateReservation(ctx, from, from.Add(time.Hour), primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown charger: %v", err)
+	}
+	if _, err := srv.CreateReservation(ctx, from, from.Add(time.Hour), known.Hex()); err != nil {
+		t.Fatalf("known charger: %v", err)
+	}
+	page, err := db.GetReservationsPage(context.Background(), ReservationFilter{}, PageRequest{})
+	if err != nil || len(page.Reservations) != 1 {
+		t.Fatalf("got %v, %v", page.Reservations, err)
+	}
+
+	// A charger decommissioned after booking cannot be moved to.
+	client.chargers = []Charger{}
+	client.generation++
+	id := page.Reservations[0].ID.Hex()
+	if _, err := srv.UpdateReservation(ctx, id, from.Add(time.Hour), from.Add(2*time.Hour), nil); !errors.Is(err, ErrChargerNotFound) {
+		t.Errorf("moving onto a removed charger: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultMaxSearchRadius is the default limit, in kilometres, for the
+// closest charger search.
+const DefaultMaxSearchRadius = 50.0
+
+type chargerDistance struct {
+	Charger  Charger
+	Distance float64
+}
+
+func calcDistance(loc1 Location, loc2 Location) (float64, error) {
+	radlat1 := float64(math.Pi * loc1.Latitude / 180)
+	radlat2 := float64(math.Pi * loc2.Latitude / 180)
+	theta := float64(loc1.Longitude - loc2.Longitude)
+	radtheta := float64(math.Pi * theta / 180)
+
+	dist := math.Sin(radlat1)*math.Sin(radlat2) + math.Cos(radlat1)*math.Cos(radlat2)*math.Cos(radtheta)
+
+	if dist > 1 {
+		dist = 1
+	}
+
+	dist = math.Acos(dist)
+	dist = dist * 180 / math.Pi
+	dist = dist * 60 * 1.1515 * 1.60934
+
+	return dist, nil
+}
+
+// closestBatch is how many chargers the closest search asks the index for
+// at first. It doubles each time those are all taken.
+const closestBatch = 8
+
+// bookClosest inserts reservation on the nearest charger within radius
+// kilometres of location that is free for its window, asking index for more
+// chargers only while the nearest ones are taken. insert must fail with
+// ErrReservationConflict when the charger is taken, and return the id of the
+// stored reservation otherwise.
+func bookClosest(ctx context.Context, index *ChargerIndex, location Location, radius float64, reservation Reservation, insert func(Reservation) (primitive.ObjectID, error)) (Reservation, error) {
+	tried := map[primitive.ObjectID]bool{}
+	for k := closestBatch; ; k *= 2 {
+		nearest, err := index.Nearest(ctx, location, k)
+		if err != nil {
+			return Reservation{}, err
+		}
+		for _, candidate := range nearest {
+			if candidate.Distance > radius {
+				return Reservation{}, ErrNoChargerAvailable
+			}
+			if tried[candidate.Charger.ID] {
+				continue
+			}
+			tried[candidate.Charger.ID] = true
+			reservation.ChargerID = candidate.Charger.ID
+			id, err := insert(reservation)
+			// A conflict only means that charger is taken for the window, so
+			// move on to the next.
+			if errors.Is(err, ErrReservationConflict) {
+				continue
+			}
+			if err != nil {
+				return Reservation{}, err
+			}
+			reservation.ID = id
+			return reservation, nil
+		}
+		if len(nearest) < k {
+			return Reservation{}, ErrNoChargerAvailable
+		}
+	}
+}
+
+// chargerCandidates describes the nearby chargers, marking those in busy as
+// unavailable.
+func chargerCandidates(nearby []chargerDistance, busy map[primitive.ObjectID]bool) []ChargerCandidate {
+	candidates := []ChargerCandidate{}
+	for _, candidate := range nearby {
+		candidates = append(candidates, ChargerCandidate{
+			ChargerID:     candidate.Charger.ID.Hex(),
+			Name:          candidate.Charger.Name,
+			Location:      candidate.Charger.Location,
+			Distance:      candidate.Distance,
+			Available:     !busy[candidate.Charger.ID],
+			AverageRating: candidate.Charger.AverageRating,
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,320 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+var errCircuitOpen = errors.New("chargers service circuit is open")
+
+// ChargersClient reads chargers from the chargers service.
+type ChargersClient interface {
+	// Chargers returns every charger the chargers service knows of.
+	Chargers(ctx context.Context) ([]Charger, error)
+	// Generation changes whenever Chargers starts returning a newly fetched
+	// list.
+	Generation() uint64
+}
+
+// ChargersClientConfig tunes how the chargers service is called.
+type ChargersClientConfig struct {
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// Attempts is how many times a request is tried, each time on the next
+	// instance.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles after that.
+	Backoff time.Duration
+	// CacheTTL is how long a fetched charger list is served without asking
+	// the chargers service again.
+	CacheTTL time.Duration
+	// BreakerFailures failed requests in a row open the circuit, which
+	// then rejects requests for BreakerCooldown before letting one through.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// DefaultChargersClientConfig is used for settings left zero.
+var DefaultChargersClientConfig = ChargersClientConfig{
+	Timeout:         5 * time.Second,
+	Attempts:        3,
+	Backoff:         100 * time.Millisecond,
+	CacheTTL:        30 * time.Second,
+	BreakerFailures: 5,
+	BreakerCooldown: 30 * time.Second,
+}
+
+type httpChargersClient struct {
+	resolver *ServiceResolver
+	config   ChargersClientConfig
+	client   *http.Client
+	breaker  *circuitBreaker
+	logger   log.Logger
+	now      func() time.Time
+
+	mu         sync.Mutex
+	cached     []Charger
+	fetched    time.Time
+	generation uint64
+	inflight   *chargersFetch
+}
+
+// chargersFetch is a request to the chargers service that concurrent
+// callers share. done is closed once chargers and err are set.
+type chargersFetch struct {
+	done     chan struct{}
+	chargers []Charger
+	err      error
+	// abandoned is set if the caller making the request gave up on it.
+	abandoned bool
+}
+
+// NewChargersClient returns a ChargersClient that calls the instances found
+// by resolver.
+func NewChargersClient(resolver *ServiceResolver, config ChargersClientConfig, logger log.Logger) ChargersClient {
+	defaults := DefaultChargersClientConfig
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = defaults.Attempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaults.Backoff
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = defaults.CacheTTL
+	}
+	if config.BreakerFailures <= 0 {
+		config.BreakerFailures = defaults.BreakerFailures
+	}
+	if config.BreakerCooldown <= 0 {
+		config.BreakerCooldown = defaults.BreakerCooldown
+	}
+	return &httpChargersClient{
+		resolver: resolver,
+		config:   config,
+		client:   &http.Client{},
+		breaker:  &circuitBreaker{threshold: config.BreakerFailures, cooldown: config.BreakerCooldown, now: time.Now},
+		logger:   log.With(logger, "component", "chargersClient"),
+		now:      time.Now,
+	}
+}
+
+// Chargers serves the cached list while it is fresh. Otherwise the first
+// caller fetches it and the others wait for that fetch, each only as long as
+// its own ctx allows.
+func (c *httpChargersClient) Chargers(ctx context.Context) ([]Charger, error) {
+	for {
+		c.mu.Lock()
+		if c.cached != nil && c.now().Sub(c.fetched) <= c.config.CacheTTL {
+			chargers := c.cached
+			c.mu.Unlock()
+			return chargers, nil
+		}
+		fetch := c.inflight
+		if fetch == nil {
+			fetch = &chargersFetch{done: make(chan struct{})}
+			c.inflight = fetch
+			c.mu.Unlock()
+			return c.fetch(ctx, fetch)
+		}
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-fetch.done:
+		}
+		if !fetch.abandoned {
+			return fetch.chargers, fetch.err
+		}
+		// The fetching caller's deadline is not ours; try again.
+	}
+}
+
+func (c *httpChargersClient) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *httpChargersClient) fetch(ctx context.Context, fetch *chargersFetch) ([]Charger, error) {
+	fetch.chargers, fetch.err = c.call(ctx)
+	fetch.abandoned = fetch.err != nil && ctx.Err() != nil
+	c.mu.Lock()
+	if fetch.err == nil {
+		c.cached = fetch.chargers
+		c.fetched = c.now()
+		c.generation++
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+	close(fetch.done)
+	return fetch.chargers, fetch.err
+}
+
+// call fetches the charger list, retrying on the next instance with
+// exponential backoff.
+func (c *httpChargersClient) call(ctx context.Context) ([]Charger, error) {
+	if !c.breaker.allow() {
+		return nil, unavailable(errCircuitOpen)
+	}
+	backoff := c.config.Backoff
+	var lastErr error
+	for attempt := 0; attempt < c.config.Attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				c.breaker.abort()
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		base, err := c.resolver.Next()
+		if err != nil {
+			lastErr = err
+			break
+		}
+		chargers, err := c.get(ctx, base)
+		if err == nil {
+			c.breaker.success()
+			return chargers, nil
+		}
+		level.Warn(c.logger).Log("msg", "fetching chargers failed", "instance", base, "attempt", attempt+1, "err", err)
+		lastErr = err
+		if ctx.Err() != nil || !retryable(err) {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		// The caller gave up; that says nothing about the chargers service.
+		c.breaker.abort()
+		return nil, ctx.Err()
+	}
+	if errors.As(lastErr, &statusError{}) && !retryable(lastErr) {
+		// The service answered, so the request rather than the service is at
+		// fault.
+		c.breaker.success()
+		return nil, unavailable(lastErr)
+	}
+	c.breaker.failure()
+	return nil, unavailable(lastErr)
+}
+
+// statusError is an unexpected response status from the chargers service.
+type statusError struct {
+	status int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("chargers service answered %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether another attempt may succeed: transport errors,
+// timeouts, throttling and server errors are, other statuses are not.
+func retryable(err error) bool {
+	status := statusError{}
+	if errors.As(err, &status) {
+		return status.status == http.StatusTooManyRequests || status.status >= 500
+	}
+	return true
+}
+
+func (c *httpChargersClient) get(ctx context.Context, base string) ([]Charger, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/chargers", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError{status: resp.StatusCode}
+	}
+	body := GetChargersResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding chargers: %w", err)
+	}
+	if body.Chargers == nil {
+		body.Chargers = []Charger{}
+	}
+	return body.Chargers, nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops calls to a failing dependency for a cooldown, then
+// lets a single trial call decide whether to close again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// The trial call is still in flight.
+		return false
+	}
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// abort ends a call that was given up on without an outcome.
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		// Let the next call be the trial instead.
+		b.state = breakerOpen
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
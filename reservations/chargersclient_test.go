@@ -0,0 +1,228 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// fakeChargersService is an httptest chargers service whose responses can
+// be switched between serving chargers and failing.
+type fakeChargersService struct {
+	*httptest.Server
+	mu       sync.Mutex
+	chargers []Charger
+	status   int
+	delay    time.Duration
+	calls    int
+}
+
+func newFakeChargersService(chargers ...Charger) *fakeChargersService {
+	svc := &fakeChargersService{chargers: chargers, status: http.StatusOK}
+	svc.Server = httptest.NewServer(http.HandlerFunc(svc.serve))
+	return svc
+}
+
+func (svc *fakeChargersService) serve(w http.ResponseWriter, r *http.Request) {
+	svc.mu.Lock()
+	svc.calls++
+	status, chargers, delay := svc.status, svc.chargers, svc.delay
+	svc.mu.Unlock()
+	if r.Method != http.MethodGet || r.URL.Path != "/chargers" {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	json.NewEncoder(w).Encode(GetChargersResponse{Chargers: chargers})
+}
+
+func (svc *fakeChargersService) set(status int, delay time.Duration) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.status, svc.delay = status, delay
+}
+
+func (svc *fakeChargersService) callCount() int {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.calls
+}
+
+func (svc *fakeChargersService) address() string {
+	return strings.TrimPrefix(svc.URL, "http://")
+}
+
+// newTestChargersClient returns a client for the given services with fast
+// retries and a controllable clock.
+func newTestChargersClient(t *testing.T, config ChargersClientConfig, services ...*fakeChargersService) (*httpChargersClient, *time.Time) {
+	health := newFakeHealth()
+	addresses := []string{}
+	for _, svc := range services {
+		addresses = append(addresses, svc.address())
+	}
+	health.set(addresses...)
+	resolver := NewServiceResolver(health, "chargers", nil, log.NewNopLogger())
+	if err := resolver.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Backoff == 0 {
+		config.Backoff = time.Millisecond
+	}
+	client := NewChargersClient(resolver, config, log.NewNopLogger()).(*httpChargersClient)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	client.breaker.now = client.now
+	return client, &now
+}
+
+func TestChargersClientCacheAndFailover(t *testing.T) {
+	broken := newFakeChargersService()
+	defer broken.Close()
+	broken.set(http.StatusBadGateway, 0)
+	working := newFakeChargersService(Charger{Name: "north"})
+	defer working.Close()
+	client, now := newTestChargersClient(t, ChargersClientConfig{CacheTTL: time.Minute}, broken, working)
+
+	chargers, err := client.Chargers(context.Background())
+	if err != nil || len(chargers) != 1 || chargers[0].Name != "north" {
+		t.Fatalf("got %v, %v", chargers, err)
+	}
+	if _, err := client.Chargers(context.Background()); err != nil || working.callCount() != 1 || client.Generation() != 1 {
+		t.Errorf("cached list not reused: %v, %d calls, generation %d", err, working.callCount(), client.Generation())
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, err := client.Chargers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.Generation() != 2 {
+		t.Errorf("refetched list kept generation %d", client.Generation())
+	}
+	if broken.callCount()+working.callCount() < 3 {
+		t.Errorf("expired cache not refreshed")
+	}
+}
+
+func TestChargersClientErrors(t *testing.T) {
+	svc := newFakeChargersService()
+	defer svc.Close()
+	client, _ := newTestChargersClient(t, ChargersClientConfig{Attempts: 3}, svc)
+
+	svc.set(http.StatusNotFound, 0)
+	if _, err := client.Chargers(context.Background()); !errors.Is(err, ErrUpstreamUnavailable) || svc.callCount() != 1 {
+		t.Errorf("client errors are not retried: %v after %d calls", err, svc.callCount())
+	}
+
+	svc.set(http.StatusServiceUnavailable, 0)
+	if _, err := client.Chargers(context.Background()); !errors.Is(err, ErrUpstreamUnavailable) || svc.callCount() != 4 {
+		t.Errorf("server errors are retried: %v after %d calls", err, svc.callCount())
+	}
+
+	svc.set(http.StatusOK, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Chargers(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("caller deadline: %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("deadline not propagated, took %v", time.Since(start))
+	}
+}
+
+func TestChargersClientCircuitBreaker(t *testing.T) {
+	svc := newFakeChargersService(Charger{Name: "north"})
+	defer svc.Close()
+	svc.set(http.StatusInternalServerError, 0)
+	client, now := newTestChargersClient(t, ChargersClientConfig{Attempts: 1, BreakerFailures: 2, BreakerCooldown: time.Minute}, svc)
+
+	for i := 0; i < 2; i++ {
+		client.Chargers(context.Background())
+	}
+	calls := svc.callCount()
+	if _, err := client.Chargers(context.Background()); !errors.Is(err, errCircuitOpen) || svc.callCount() != calls {
+		t.Errorf("open circuit should not call the service: %v", err)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if _, err := client.Chargers(context.Background()); err == nil || svc.callCount() != calls+1 {
+		t.Errorf("half-open circuit should let one trial through: %v", err)
+	}
+	if _, err := client.Chargers(context.Background()); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("failed trial should reopen the circuit: %v", err)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	svc.set(http.StatusOK, 0)
+	if chargers, err := client.Chargers(context.Background()); err != nil || len(chargers) != 1 {
+		t.Errorf("successful trial should close the circuit: %v, %v", chargers, err)
+	}
+}
+
+func TestChargersClientBreakerIgnoresClientErrors(t *testing.T) {
+	svc := newFakeChargersService()
+	defer svc.Close()
+	svc.set(http.StatusNotFound, 0)
+	client, _ := newTestChargersClient(t, ChargersClientConfig{Attempts: 1, BreakerFailures: 2}, svc)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Chargers(context.Background()); errors.Is(err, errCircuitOpen) {
+			t.Fatalf("client error %d opened the circuit", i+1)
+		}
+	}
+	if svc.callCount() != 3 {
+		t.Errorf("got %d calls, want 3", svc.callCount())
+	}
+}
+
+func TestChargersClientSharedFetch(t *testing.T) {
+	svc := newFakeChargersService(Charger{Name: "north"})
+	defer svc.Close()
+	svc.set(http.StatusOK, 200*time.Millisecond)
+	client, _ := newTestChargersClient(t, ChargersClientConfig{}, svc)
+
+	// The first caller fetches; a waiter with a shorter deadline gives up on
+	// its own schedule.
+	leader := make(chan error, 1)
+	go func() {
+		_, err := client.Chargers(context.Background())
+		leader <- err
+	}()
+	waitFor(t, "fetch in flight", func() bool { return svc.callCount() == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Chargers(ctx); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 150*time.Millisecond {
+		t.Errorf("waiter ignored its deadline: %v after %v", err, time.Since(start))
+	}
+	if err := <-leader; err != nil || svc.callCount() != 1 {
+		t.Errorf("shared fetch: %v, %d calls", err, svc.callCount())
+	}
+
+	// A waiter whose fetching caller gives up fetches for itself.
+	client.mu.Lock()
+	client.cached = nil
+	client.mu.Unlock()
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	go client.Chargers(short)
+	waitFor(t, "second fetch in flight", func() bool { return svc.callCount() == 2 })
+	if chargers, err := client.Chargers(context.Background()); err != nil || len(chargers) != 1 {
+		t.Errorf("waiter after an abandoned fetch: %v, %v", chargers, err)
+	}
+}
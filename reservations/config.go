@@ -0,0 +1,434 @@
+package reservations
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the service configuration. Each setting is resolved from, in
+// increasing precedence: its default, the JSON config file, the environment
+// and the command line.
+type Config struct {
+	ConfigFile string
+
+	HTTPAddr         string
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	ConsulAddr       string
+	ConsulTimeout    time.Duration
+	ShutdownTimeout  time.Duration
+
+	Register        bool
+	ServiceID       string
+	ServiceName     string
+	ServiceAddress  string
+	ServiceTags     string
+	HealthInterval  time.Duration
+	HealthTTL       time.Duration
+	DeregisterAfter time.Duration
+
+	Storage             string
+	MongoURI            string
+	MongoPassword       string
+	MongoDatabase       string
+	MongoConnectTimeout time.Duration
+	PostgresDSN         string
+	Migrate             bool
+	MigrateTimeout      time.Duration
+
+	ScoreDistanceWeight float64
+	ScoreRatingWeight   float64
+	// MaxSearchRadius bounds, in kilometres, how far the database looks for
+	// chargers when booking or searching by location. Every ReservationDB
+	// takes it as its maxSearchRadius.
+	MaxSearchRadius      float64
+	ChargersService      string
+	ChargersRefresh      time.Duration
+	ChargersTimeout      time.Duration
+	ChargersAttempts     int
+	ChargersCache        time.Duration
+	ChargerCheck         string
+	IdempotencyRetention time.Duration
+
+	JWKS          string
+	JWTAudience   string
+	JWTIssuer     string
+	JWTAlgorithms string
+
+	// Args are the arguments left after the flags, e.g. the migrate command.
+	Args []string
+}
+
+// DefaultConfig returns the configuration used when nothing overrides it.
+func DefaultConfig() Config {
+	return Config{
+		HTTPAddr:             ":8080",
+		HTTPReadTimeout:      15 * time.Second,
+		HTTPWriteTimeout:     30 * time.Second,
+		ConsulAddr:           "127.0.0.1:8500",
+		ConsulTimeout:        10 * time.Second,
+		ShutdownTimeout:      15 * time.Second,
+		Register:             true,
+		ServiceName:          "reservations",
+		HealthInterval:       10 * time.Second,
+		HealthTTL:            30 * time.Second,
+		DeregisterAfter:      10 * time.Minute,
+		Storage:              "mongo",
+		MongoDatabase:        "Reservations",
+		MongoConnectTimeout:  10 * time.Second,
+		Migrate:              true,
+		MigrateTimeout:       10 * time.Minute,
+		ScoreDistanceWeight:  DefaultScorer.DistanceWeight,
+		ScoreRatingWeight:    DefaultScorer.RatingWeight,
+		MaxSearchRadius:      DefaultMaxSearchRadius,
+		ChargersService:      DefaultChargersService,
+		ChargersRefresh:      DefaultIndexRefresh,
+		ChargersTimeout:      DefaultChargersClientConfig.Timeout,
+		ChargersAttempts:     DefaultChargersClientConfig.Attempts,
+		ChargersCache:        DefaultChargersClientConfig.CacheTTL,
+		ChargerCheck:         string(ChargerCheckLenient),
+		IdempotencyRetention: DefaultIdempotencyRetention,
+		JWTAlgorithms:        strings.Join(DefaultAlgorithms, ","),
+	}
+}
+
+// configEnv maps settings to the environment variables that set them.
+var configEnv = map[string]string{
+	"config":                "RESERVATIONS_CONFIG",
+	"http":                  "HTTP_ADDR",
+	"http-read-timeout":     "HTTP_READ_TIMEOUT",
+	"http-write-timeout":    "HTTP_WRITE_TIMEOUT",
+	"consul-addr":           "CONSUL_ADDR",
+	"consul-timeout":        "CONSUL_TIMEOUT",
+	"shutdown-timeout":      "SHUTDOWN_TIMEOUT",
+	"register":              "CONSUL_REGISTER",
+	"service-id":            "SERVICE_ID",
+	"service-name":          "SERVICE_NAME",
+	"service-address":       "SERVICE_ADDRESS",
+	"service-tags":          "SERVICE_TAGS",
+	"health-interval":       "HEALTH_INTERVAL",
+	"health-ttl":            "HEALTH_TTL",
+	"deregister-after":      "DEREGISTER_AFTER",
+	"storage":               "STORAGE",
+	"mongo-uri":             "MONGO_URI",
+	"mongo-password":        "DBpw",
+	"mongo-db":              "MONGO_DATABASE",
+	"mongo-timeout":         "MONGO_TIMEOUT",
+	"postgres-dsn":          "POSTGRES_DSN",
+	"migrate":               "MIGRATE",
+	"migrate-timeout":       "MIGRATE_TIMEOUT",
+	"score-distance-weight": "SCORE_DISTANCE_WEIGHT",
+	"score-rating-weight":   "SCORE_RATING_WEIGHT",
+	"max-radius":            "MAX_RADIUS",
+	"chargers-service":      "CHARGERS_SERVICE",
+	"chargers-refresh":      "CHARGERS_REFRESH",
+	"chargers-timeout":      "CHARGERS_TIMEOUT",
+	"chargers-attempts":     "CHARGERS_ATTEMPTS",
+	"chargers-cache":        "CHARGERS_CACHE",
+	"charger-check":         "CHARGER_CHECK",
+	"idempotency-retention": "IDEMPOTENCY_RETENTION",
+	"jwks":                  "JWKS",
+	"jwt-audience":          "JWT_AUDIENCE",
+	"jwt-issuer":            "JWT_ISSUER",
+	"jwt-algs":              "JWT_ALGS",
+}
+
+// secretSettings are redacted when the configuration is printed.
+var secretSettings = map[string]bool{
+	"mongo-uri":      true,
+	"mongo-password": true,
+	"postgres-dsn":   true,
+}
+
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "JSON config file keyed by flag name")
+	fs.StringVar(&c.HTTPAddr, "http", c.HTTPAddr, "http listen addr")
+	fs.DurationVar(&c.HTTPReadTimeout, "http-read-timeout", c.HTTPReadTimeout, "how long reading a request may take")
+	fs.DurationVar(&c.HTTPWriteTimeout, "http-write-timeout", c.HTTPWriteTimeout, "how long writing a response may take")
+	fs.StringVar(&c.ConsulAddr, "consul-addr", c.ConsulAddr, "Consul agent address")
+	fs.DurationVar(&c.ConsulTimeout, "consul-timeout", c.ConsulTimeout, "how long loading keys from Consul may take")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long in-flight requests may take to finish on shutdown")
+	fs.BoolVar(&c.Register, "register", c.Register, "register the service and its health checks in Consul")
+	fs.StringVar(&c.ServiceID, "service-id", c.ServiceID, "Consul service ID; defaults to the name, host name and port")
+	fs.StringVar(&c.ServiceName, "service-name", c.ServiceName, "Consul service name")
+	fs.StringVar(&c.ServiceAddress, "service-address", c.ServiceAddress, "address other services reach this instance on; defaults to the hostname")
+	fs.StringVar(&c.ServiceTags, "service-tags", c.ServiceTags, "comma separated Consul service tags")
+	fs.DurationVar(&c.HealthInterval, "health-interval", c.HealthInterval, "how often Consul polls the health route")
+	fs.DurationVar(&c.HealthTTL, "health-ttl", c.HealthTTL, "how long Consul waits for a heartbeat before marking the instance critical")
+	fs.DurationVar(&c.DeregisterAfter, "deregister-after", c.DeregisterAfter, "how long an instance may stay critical before Consul removes it")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "reservation storage backend: mongo, postgres or memory")
+	fs.StringVar(&c.MongoURI, "mongo-uri", c.MongoURI, "MongoDB connection string for -storage=mongo")
+	fs.StringVar(&c.MongoPassword, "mongo-password", c.MongoPassword, "password added to the MongoDB connection string")
+	fs.StringVar(&c.MongoDatabase, "mongo-db", c.MongoDatabase, "MongoDB database name")
+	fs.DurationVar(&c.MongoConnectTimeout, "mongo-timeout", c.MongoConnectTimeout, "how long connecting to MongoDB may take")
+	fs.StringVar(&c.PostgresDSN, "postgres-dsn", c.PostgresDSN, "PostgreSQL connection string for -storage=postgres")
+	fs.BoolVar(&c.Migrate, "migrate", c.Migrate, "apply pending Mongo migrations at startup; run with the migrate argument to only migrate")
+	fs.DurationVar(&c.MigrateTimeout, "migrate-timeout", c.MigrateTimeout, "how long migrations may take")
+	fs.Float64Var(&c.ScoreDistanceWeight, "score-distance-weight", c.ScoreDistanceWeight, "weight of closeness when ranking chargers")
+	fs.Float64Var(&c.ScoreRatingWeight, "score-rating-weight", c.ScoreRatingWeight, "weight of average rating when ranking chargers")
+	fs.Float64Var(&c.MaxSearchRadius, "max-radius", c.MaxSearchRadius, "max distance in km for closest charger reservations")
+	fs.StringVar(&c.ChargersService, "chargers-service", c.ChargersService, "Consul service name of the chargers service")
+	fs.DurationVar(&c.ChargersRefresh, "chargers-refresh", c.ChargersRefresh, "how often to reload the charger location index")
+	fs.DurationVar(&c.ChargersTimeout, "chargers-timeout", c.ChargersTimeout, "how long one request to the chargers service may take")
+	fs.IntVar(&c.ChargersAttempts, "chargers-attempts", c.ChargersAttempts, "how many times a request to the chargers service is tried")
+	fs.DurationVar(&c.ChargersCache, "chargers-cache", c.ChargersCache, "how long a fetched charger list is reused")
+	fs.StringVar(&c.ChargerCheck, "charger-check", c.ChargerCheck, "how reservations are checked against the chargers service: strict, cached-lenient or off")
+	fs.DurationVar(&c.IdempotencyRetention, "idempotency-retention", c.IdempotencyRetention, "how long responses are replayed for a repeated Idempotency-Key")
+	fs.StringVar(&c.JWKS, "jwks", c.JWKS, "JWKS file path or URL with RS256/ES256 token verification keys")
+	fs.StringVar(&c.JWTAudience, "jwt-audience", c.JWTAudience, "required token audience")
+	fs.StringVar(&c.JWTIssuer, "jwt-issuer", c.JWTIssuer, "required token issuer")
+	fs.StringVar(&c.JWTAlgorithms, "jwt-algs", c.JWTAlgorithms, "accepted token signing algorithms")
+	return fs
+}
+
+// LoadConfig resolves the configuration from the defaults, the config file
+// named by -config or RESERVATIONS_CONFIG, the environment and args. It
+// returns flag.ErrHelp when args ask for usage.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	fs := cfg.flagSet("reservations")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	// Flags win over everything, so remember them and replay them last.
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	remaining := fs.Args()
+
+	cfg = DefaultConfig()
+	fs = cfg.flagSet("reservations")
+	path := getenv(configEnv["config"])
+	if p, ok := flags["config"]; ok {
+		path = p
+	}
+	if path != "" {
+		if err := loadConfigFile(fs, path); err != nil {
+			return Config{}, err
+		}
+	}
+	for name, env := range configEnv {
+		if value := getenv(env); value != "" {
+			if err := fs.Set(name, value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	for name, value := range flags {
+		if err := fs.Set(name, value); err != nil {
+			return Config{}, fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+	cfg.ConfigFile = path
+	cfg.Args = remaining
+	return cfg, nil
+}
+
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	settings := map[string]interface{}{}
+	if err := decoder.Decode(&settings); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	for name, value := range settings {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports every setting that would keep the service from running.
+func (c Config) Validate() error {
+	problems := []string{}
+	if c.HTTPAddr == "" {
+		problems = append(problems, "http: must not be empty")
+	}
+	switch c.Storage {
+	case "mongo":
+		if c.MongoURI == "" {
+			problems = append(problems, "mongo-uri: required for mongo storage")
+		} else if u, err := url.Parse(c.MongoURI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			problems = append(problems, "mongo-uri: must be a mongodb:// or mongodb+srv:// URI")
+		}
+		if c.MongoDatabase == "" {
+			problems = append(problems, "mongo-db: must not be empty")
+		}
+	case "postgres":
+		if c.PostgresDSN == "" {
+			problems = append(problems, "postgres-dsn: required for postgres storage")
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("storage: unknown backend %q", c.Storage))
+	}
+	positive := map[string]time.Duration{
+		"http-read-timeout":     c.HTTPReadTimeout,
+		"http-write-timeout":    c.HTTPWriteTimeout,
+		"consul-timeout":        c.ConsulTimeout,
+		"shutdown-timeout":      c.ShutdownTimeout,
+		"health-interval":       c.HealthInterval,
+		"health-ttl":            c.HealthTTL,
+		"deregister-after":      c.DeregisterAfter,
+		"mongo-timeout":         c.MongoConnectTimeout,
+		"migrate-timeout":       c.MigrateTimeout,
+		"chargers-refresh":      c.ChargersRefresh,
+		"chargers-timeout":      c.ChargersTimeout,
+		"chargers-cache":        c.ChargersCache,
+		"idempotency-retention": c.IdempotencyRetention,
+	}
+	for name, d := range positive {
+		if d <= 0 {
+			problems = append(problems, name+": must be positive")
+		}
+	}
+	if c.Register {
+		if c.ServiceName == "" {
+			problems = append(problems, "service-name: required to register in consul")
+		}
+		if _, err := c.ServicePort(); err != nil {
+			problems = append(problems, "http: "+err.Error())
+		}
+		if _, err := c.AdvertiseAddress(); err != nil {
+			problems = append(problems, "service-address: "+err.Error())
+		}
+	}
+	if _, err := ParseChargerCheckMode(c.ChargerCheck); err != nil {
+		problems = append(problems, "charger-check: "+err.Error())
+	}
+	if c.ChargersAttempts < 1 {
+		problems = append(problems, "chargers-attempts: must be at least 1")
+	}
+	if c.ChargersService == "" {
+		problems = append(problems, "chargers-service: must not be empty")
+	}
+	if c.ScoreDistanceWeight < 0 || c.ScoreRatingWeight < 0 {
+		problems = append(problems, "score weights: must not be negative")
+	}
+	if c.MaxSearchRadius <= 0 {
+		problems = append(problems, "max-radius: must be positive")
+	}
+	for _, alg := range c.Algorithms() {
+		if alg == "" {
+			problems = append(problems, "jwt-algs: empty algorithm")
+			break
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New("invalid config: " + strings.Join(problems, "; "))
+}
+
+// Algorithms returns the accepted token signing algorithms.
+func (c Config) Algorithms() []string {
+	return strings.Split(c.JWTAlgorithms, ",")
+}
+
+// Tags returns the Consul service tags.
+func (c Config) Tags() []string {
+	tags := []string{}
+	for _, tag := range strings.Split(c.ServiceTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ServicePort returns the port of HTTPAddr.
+func (c Config) ServicePort() (int, error) {
+	_, port, err := net.SplitHostPort(c.HTTPAddr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("listen address %q has no fixed port", c.HTTPAddr)
+	}
+	return n, nil
+}
+
+// hostname is swapped out by tests.
+var hostname = os.Hostname
+
+// AdvertiseAddress returns the host other services reach this instance on:
+// ServiceAddress if set, otherwise the hostname.
+func (c Config) AdvertiseAddress() (string, error) {
+	if c.ServiceAddress != "" {
+		if _, _, err := net.SplitHostPort(c.ServiceAddress); err == nil || strings.ContainsAny(c.ServiceAddress, "/ ") {
+			return "", fmt.Errorf("%q must be a host name or IP address without a port", c.ServiceAddress)
+		}
+		return c.ServiceAddress, nil
+	}
+	host, err := hostname()
+	if err != nil || host == "" {
+		return "", errors.New("cannot determine the hostname, set an address to register")
+	}
+	return host, nil
+}
+
+// MongoConnectionURI returns MongoURI with MongoPassword filled in, so the
+// password can be kept out of the URI in files and process listings.
+func (c Config) MongoConnectionURI() string {
+	if c.MongoPassword == "" {
+		return c.MongoURI
+	}
+	u, err := url.Parse(c.MongoURI)
+	if err != nil || u.User == nil {
+		return c.MongoURI
+	}
+	u.User = url.UserPassword(u.User.Username(), c.MongoPassword)
+	return u.String()
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S*)`)
+
+// Redacted returns the settings as logger keyvals, in flag name order, with
+// secrets masked.
+func (c Config) Redacted() []interface{} {
+	keyvals := []interface{}{}
+	c.flagSet("").VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretSettings[f.Name] && value != "" {
+			value = redact(value)
+		}
+		keyvals = append(keyvals, f.Name, value)
+	})
+	return keyvals
+}
+
+func redact(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+		if u.User == nil {
+			return value
+		}
+		return u.Redacted()
+	}
+	if dsnPassword.MatchString(value) {
+		return dsnPassword.ReplaceAllString(value, "${1}xxxxx")
+	}
+	return "xxxxx"
+}
@@ -0,0 +1,489 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// conformanceRadius is the maximum search radius, in kilometres, that the
+// backends under test are opened with.
+const conformanceRadius = 10.0
+
+// openReservationDB returns an empty ReservationDB that finds chargers in
+// chargers.
+type openReservationDB func(t *testing.T, chargers *ChargerIndex) ReservationDB
+
+// testReservationDB checks the behaviour every ReservationDB implementation
+// must share.
+func testReservationDB(t *testing.T, open openReservationDB) {
+	tests := map[string]func(t *testing.T, open openReservationDB){
+		"CreateAndGet":      conformCreateAndGet,
+		"Errors":            conformErrors,
+		"Overlap":           conformOverlap,
+		"Update":            conformUpdate,
+		"Delete":            conformDelete,
+		"StateTransition":   conformStateTransition,
+		"Filter":            conformFilter,
+		"Pagination":        conformPagination,
+		"Series":            conformSeries,
+		"Closest":           conformClosest,
+		"ClosestBusy":       conformClosestBusy,
+		"ClosestNoChargers": conformClosestNoChargers,
+		"ConcurrentCreates": conformConcurrentCreates,
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) { test(t, open) })
+	}
+}
+
+var conformanceOrigin = Location{Latitude: 46.05, Longitude: 14.50}
+
+// hour returns the start of the h-th hour of a day safely in the future.
+func hour(h int) time.Time {
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(72 * time.Hour)
+	return day.Add(time.Duration(h) * time.Hour)
+}
+
+// mustCreate books [from, to) on charger for user and returns the stored
+// reservation.
+func mustCreate(t *testing.T, db ReservationDB, user primitive.ObjectID, charger primitive.ObjectID, from time.Time, to time.Time) Reservation {
+	t.Helper()
+	ctx := context.Background()
+	if err := db.CreateReservation(ctx, from, to, user.Hex(), charger.Hex()); err != nil {
+		t.Fatalf("creating %v-%v: %v", from, to, err)
+	}
+	found, err := db.GetReservationsFilter(ctx, ReservationFilter{UserID: user.Hex(), ChargerID: charger.Hex(), From: from, To: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range found {
+		if r.From.Equal(from) && r.To.Equal(to) {
+			return r
+		}
+	}
+	t.Fatalf("created reservation %v-%v not found", from, to)
+	return Reservation{}
+}
+
+func ids(reservations []Reservation) []primitive.ObjectID {
+	result := []primitive.ObjectID{}
+	for _, r := range reservations {
+		result = append(result, r.ID)
+	}
+	return result
+}
+
+func conformCreateAndGet(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	created := mustCreate(t, db, user, charger, hour(8), hour(9))
+
+	got, err := db.GetReservation(context.Background(), created.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != user || got.ChargerID != charger || !got.From.Equal(hour(8)) || !got.To.Equal(hour(9)) {
+		t.Errorf("got %+v", got)
+	}
+	if got.State != StatePending || got.Version != 1 || len(got.History) != 1 || got.Created.IsZero() {
+		t.Errorf("new reservation has state %s, version %d, history %v, created %v", got.State, got.Version, got.History, got.Created)
+	}
+}
+
+func conformErrors(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	missing := primitive.NewObjectID().Hex()
+	if _, err := db.GetReservation(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get missing: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, missing, hour(1), hour(2), AnyVersion); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update missing: %v", err)
+	}
+	if err := db.DeleteReservation(ctx, missing, AnyVersion); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete missing: %v", err)
+	}
+	if _, err := db.UpdateReservationState(ctx, missing, StatePending, StateTransition{To: StateConfirmed}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("transition missing: %v", err)
+	}
+	if _, err := db.GetReservation(ctx, "nope"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("get malformed id: %v", err)
+	}
+	if err := db.CreateReservation(ctx, hour(1), hour(2), primitive.NewObjectID().Hex(), "nope"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("create with malformed charger: %v", err)
+	}
+	if _, err := db.GetReservationsFilter(ctx, ReservationFilter{ChargerIDs: []string{"nope"}}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("filter with malformed charger: %v", err)
+	}
+}
+
+func conformOverlap(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	first := mustCreate(t, db, user, charger, hour(0), hour(2))
+
+	for _, window := range [][2]time.Time{{hour(1), hour(3)}, {hour(-1), hour(1)}, {hour(0), hour(2)}, {hour(-1), hour(3)}} {
+		if err := db.CreateReservation(ctx, window[0], window[1], user.Hex(), charger.Hex()); !errors.Is(err, ErrReservationConflict) {
+			t.Errorf("overlapping create %v-%v: %v", window[0], window[1], err)
+		}
+	}
+	second := mustCreate(t, db, user, charger, hour(2), hour(4))
+	mustCreate(t, db, user, charger, hour(-1), hour(0))
+	mustCreate(t, db, user, primitive.NewObjectID(), hour(1), hour(3))
+
+	if err := db.UpdateReservation(ctx, second.ID.Hex(), hour(1), hour(4), AnyVersion); !errors.Is(err, ErrReservationConflict) {
+		t.Errorf("overlapping update: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, second.ID.Hex(), hour(3), hour(5), AnyVersion); err != nil {
+		t.Errorf("update overlapping only itself: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, second.ID.Hex(), hour(2), hour(3), AnyVersion); err != nil {
+		t.Errorf("update adjacent to another booking: %v", err)
+	}
+
+	transition := StateTransition{From: StatePending, To: StateCancelled, At: time.Now(), Actor: user.Hex()}
+	if _, err := db.UpdateReservationState(ctx, first.ID.Hex(), StatePending, transition); err != nil {
+		t.Fatal(err)
+	}
+	mustCreate(t, db, user, charger, hour(1), hour(2))
+}
+
+func conformUpdate(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	first := mustCreate(t, db, user, charger, hour(0), hour(1))
+	mustCreate(t, db, user, charger, hour(2), hour(3))
+
+	if err := db.UpdateReservation(ctx, first.ID.Hex(), hour(2), hour(3), 1); !errors.Is(err, ErrReservationConflict) {
+		t.Errorf("moving onto another booking: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, first.ID.Hex(), hour(0), hour(2), 1); err != nil {
+		t.Fatalf("growing into free time: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, first.ID.Hex(), hour(4), hour(5), 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version: %v", err)
+	}
+	if err := db.UpdateReservation(ctx, first.ID.Hex(), hour(4), hour(5), AnyVersion); err != nil {
+		t.Errorf("any version: %v", err)
+	}
+	got, err := db.GetReservation(ctx, first.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 3 || !got.From.Equal(hour(4)) || !got.To.Equal(hour(5)) {
+		t.Errorf("after updates got version %d, window %v-%v", got.Version, got.From, got.To)
+	}
+}
+
+func conformDelete(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	r := mustCreate(t, db, primitive.NewObjectID(), primitive.NewObjectID(), hour(0), hour(1))
+	if err := db.DeleteReservation(ctx, r.ID.Hex(), 2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version: %v", err)
+	}
+	if err := db.DeleteReservation(ctx, r.ID.Hex(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetReservation(ctx, r.ID.Hex()); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("deleted reservation still readable: %v", err)
+	}
+}
+
+func conformStateTransition(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	user := primitive.NewObjectID()
+	r := mustCreate(t, db, user, primitive.NewObjectID(), hour(0), hour(1))
+	transition := StateTransition{From: StatePending, To: StateConfirmed, At: time.Now(), Actor: user.Hex()}
+
+	updated, err := db.UpdateReservationState(ctx, r.ID.Hex(), StatePending, transition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.State != StateConfirmed || updated.Version != 2 || len(updated.History) != 2 || updated.History[1].Actor != user.Hex() {
+		t.Errorf("got state %s, version %d, history %v", updated.State, updated.Version, updated.History)
+	}
+	if _, err := db.UpdateReservationState(ctx, r.ID.Hex(), StatePending, transition); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("transition from a stale state: %v", err)
+	}
+}
+
+func conformFilter(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	c1, c2, c3 := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	a := mustCreate(t, db, alice, c1, hour(0), hour(1))
+	b := mustCreate(t, db, bob, c2, hour(2), hour(3))
+	c := mustCreate(t, db, alice, c3, hour(4), hour(5))
+	transition := StateTransition{From: StatePending, To: StateConfirmed, At: time.Now(), Actor: alice.Hex()}
+	if _, err := db.UpdateReservationState(ctx, c.ID.Hex(), StatePending, transition); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		filter ReservationFilter
+		want   []primitive.ObjectID
+	}{
+		"user":            {ReservationFilter{UserID: alice.Hex()}, []primitive.ObjectID{a.ID, c.ID}},
+		"charger":         {ReservationFilter{ChargerID: c2.Hex()}, []primitive.ObjectID{b.ID}},
+		"chargers":        {ReservationFilter{ChargerIDs: []string{c1.Hex(), c2.Hex()}}, []primitive.ObjectID{a.ID, b.ID}},
+		"charger in list": {ReservationFilter{ChargerID: c1.Hex(), ChargerIDs: []string{c2.Hex()}}, []primitive.ObjectID{}},
+		"state":           {ReservationFilter{States: []ReservationState{StateConfirmed}}, []primitive.ObjectID{c.ID}},
+		"pending":         {ReservationFilter{States: []ReservationState{StatePending}}, []primitive.ObjectID{a.ID, b.ID}},
+		"window":          {ReservationFilter{From: hour(0).Add(30 * time.Minute), To: hour(2).Add(time.Minute)}, []primitive.ObjectID{a.ID, b.ID}},
+		"open window":     {ReservationFilter{From: hour(3)}, []primitive.ObjectID{c.ID}},
+		"created before":  {ReservationFilter{CreatedTo: time.Now().Add(-time.Hour)}, []primitive.ObjectID{}},
+		"created since":   {ReservationFilter{CreatedFrom: time.Now().Add(-time.Hour), UserID: bob.Hex()}, []primitive.ObjectID{b.ID}},
+	}
+	for name, c := range cases {
+		found, err := db.GetReservationsFilter(ctx, c.filter)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !sameIDs(ids(found), c.want) {
+			t.Errorf("%s: got %v, want %v", name, ids(found), c.want)
+		}
+	}
+}
+
+func sameIDs(got []primitive.ObjectID, want []primitive.ObjectID) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func conformPagination(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	booked := []primitive.ObjectID{}
+	for _, h := range []int{2, 4, 6, 8, 10} {
+		booked = append(booked, mustCreate(t, db, user, charger, hour(h), hour(h+1)).ID)
+	}
+	filter := ReservationFilter{UserID: user.Hex()}
+
+	page := PageRequest{Sort: SortByFrom, Limit: 2}
+	first, err := db.GetReservationsPage(ctx, filter, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(first.Reservations); len(got) != 2 || got[0] != booked[0] || got[1] != booked[1] || first.NextCursor == "" {
+		t.Fatalf("first page: %v, cursor %q", got, first.NextCursor)
+	}
+
+	// A booking before the cursor must not shift the following pages.
+	mustCreate(t, db, user, charger, hour(0), hour(1))
+	seen := ids(first.Reservations)
+	page.Cursor = first.NextCursor
+	for page.Cursor != "" {
+		next, err := db.GetReservationsPage(ctx, filter, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, ids(next.Reservations)...)
+		page.Cursor = next.NextCursor
+	}
+	if len(seen) != len(booked) {
+		t.Fatalf("paged through %v, want %v", seen, booked)
+	}
+	for i := range booked {
+		if seen[i] != booked[i] {
+			t.Fatalf("paged through %v, want %v", seen, booked)
+		}
+	}
+
+	newest, err := db.GetReservationsPage(ctx, filter, PageRequest{Sort: SortByFrom, Descending: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(newest.Reservations); len(got) != 1 || got[0] != booked[4] {
+		t.Errorf("descending first page: %v", got)
+	}
+	if _, err := db.GetReservationsPage(ctx, filter, PageRequest{Sort: SortByCreated, Cursor: first.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor reused with another sort: %v", err)
+	}
+}
+
+func conformSeries(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	ctx := context.Background()
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	mustCreate(t, db, user, charger, hour(24), hour(25))
+	series := ReservationSeries{ChargerID: charger, UserID: user, RRule: "FREQ=DAILY;COUNT=3", From: hour(0), To: hour(1), Created: time.Now().UTC()}
+	occurrences := []Interval{{From: hour(0), To: hour(1)}, {From: hour(24), To: hour(25)}, {From: hour(48), To: hour(49)}}
+
+	series, results, err := db.CreateSeries(ctx, series, occurrences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.ID.IsZero() {
+		t.Error("series has no id")
+	}
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	if len(statuses) != 3 || statuses[0] != OccurrenceBooked || statuses[1] != OccurrenceConflict || statuses[2] != OccurrenceBooked {
+		t.Errorf("got occurrence statuses %v", statuses)
+	}
+	found, err := db.GetReservationsFilter(ctx, ReservationFilter{SeriesID: series.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("series has %d stored occurrences, want 2", len(found))
+	}
+	if err := db.MoveSeries(ctx, series.ID.Hex(), 24*time.Hour, 2*time.Hour); err != nil {
+		t.Errorf("moving series: %v", err)
+	}
+	if err := db.MoveSeries(ctx, primitive.NewObjectID().Hex(), time.Hour, time.Hour); !errors.Is(err, ErrSeriesNotFound) {
+		t.Errorf("moving unknown series: %v", err)
+	}
+
+	following, err := db.SplitSeries(ctx, series.ID.Hex(), hour(48), 0, time.Hour, []string{results[2].ReservationID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if following.ID.IsZero() || following.ID == series.ID {
+		t.Errorf("split off series has id %s", following.ID.Hex())
+	}
+	for id, want := range map[primitive.ObjectID]int{series.ID: 1, following.ID: 1} {
+		found, err := db.GetReservationsFilter(ctx, ReservationFilter{SeriesID: id.Hex()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != want {
+			t.Errorf("series %s has %d occurrences after the split, want %d", id.Hex(), len(found), want)
+		}
+	}
+	if _, err := db.SplitSeries(ctx, primitive.NewObjectID().Hex(), hour(48), 0, time.Hour, nil); !errors.Is(err, ErrSeriesNotFound) {
+		t.Errorf("splitting unknown series: %v", err)
+	}
+}
+
+func conformClosest(t *testing.T, open openReservationDB) {
+	near := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 46.06, Longitude: 14.50}}
+	farther := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 46.08, Longitude: 14.50}}
+	outside := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 47.05, Longitude: 14.50}}
+	db := open(t, newTestIndex([]Charger{outside, farther, near}))
+	ctx := context.Background()
+	user := primitive.NewObjectID().Hex()
+
+	candidates, err := db.FindChargers(ctx, hour(0), hour(1), conformanceOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || !candidates[0].Available || !candidates[1].Available {
+		t.Fatalf("got candidates %+v", candidates)
+	}
+
+	for _, want := range []Charger{near, farther} {
+		r, err := db.ReservationClosest(ctx, user, hour(0), hour(1), conformanceOrigin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ChargerID != want.ID || r.ID.IsZero() {
+			t.Errorf("booked charger %s, want %s", r.ChargerID.Hex(), want.ID.Hex())
+		}
+	}
+	if _, err := db.ReservationClosest(ctx, user, hour(0), hour(1), conformanceOrigin); !errors.Is(err, ErrNoChargerAvailable) {
+		t.Errorf("with every charger in range busy: %v", err)
+	}
+
+	candidates, err = db.FindChargers(ctx, hour(0), hour(1), conformanceOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, candidate := range candidates {
+		if candidate.Available {
+			t.Errorf("charger %s should be busy", candidate.ChargerID)
+		}
+	}
+}
+
+func conformClosestBusy(t *testing.T, open openReservationDB) {
+	near := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 46.06, Longitude: 14.50}}
+	// About 9 and 11 km north of the origin, either side of the search radius.
+	edge := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 46.13, Longitude: 14.50}}
+	beyond := Charger{ID: primitive.NewObjectID(), Location: Location{Latitude: 46.15, Longitude: 14.50}}
+	db := open(t, newTestIndex([]Charger{beyond, edge, near}))
+	ctx := context.Background()
+	user := primitive.NewObjectID()
+	start := hour(0).Add(30 * time.Minute)
+
+	mustCreate(t, db, primitive.NewObjectID(), near.ID, hour(0), hour(1))
+	r, err := db.ReservationClosest(ctx, user.Hex(), start, start.Add(time.Hour), conformanceOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChargerID != edge.ID {
+		t.Errorf("booked charger %s, want the free one inside the radius %s", r.ChargerID.Hex(), edge.ID.Hex())
+	}
+	if _, err := db.ReservationClosest(ctx, user.Hex(), start, start.Add(time.Hour), conformanceOrigin); !errors.Is(err, ErrNoChargerAvailable) {
+		t.Errorf("booked past the search radius: %v", err)
+	}
+	r, err = db.ReservationClosest(ctx, user.Hex(), hour(1), hour(2), conformanceOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChargerID != near.ID {
+		t.Errorf("after the nearest charger frees up booked %s, want %s", r.ChargerID.Hex(), near.ID.Hex())
+	}
+}
+
+func conformClosestNoChargers(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	_, err := db.ReservationClosest(context.Background(), primitive.NewObjectID().Hex(), hour(0), hour(1), conformanceOrigin)
+	if !errors.Is(err, ErrNoChargerAvailable) {
+		t.Errorf("without chargers: %v", err)
+	}
+}
+
+func conformConcurrentCreates(t *testing.T, open openReservationDB) {
+	db := open(t, newTestIndex(nil))
+	charger := primitive.NewObjectID().Hex()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.CreateReservation(context.Background(), hour(0), hour(1), primitive.NewObjectID().Hex(), charger)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	booked := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, ErrReservationConflict):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if booked != 1 {
+		t.Errorf("%d concurrent bookings of the same slot succeeded", booked)
+	}
+}
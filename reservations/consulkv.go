@@ -0,0 +1,272 @@
+package reservations
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Consul keys read by the service.
+const (
+	KeyJWTSecret       = "jwtSecret"
+	KeyChargersService = "chargersService"
+)
+
+// consulWait bounds each blocking query, so that a watch notices a lost
+// agent within a reasonable time.
+const consulWait = 5 * time.Minute
+
+// KV is the part of the Consul KV API that ConsulConfig uses.
+// *consulapi.KV satisfies it.
+type KV interface {
+	Get(key string, q *consulapi.QueryOptions) (*consulapi.KVPair, *consulapi.QueryMeta, error)
+}
+
+// ConsulKey is a key loaded by ConsulConfig.
+type ConsulKey struct {
+	Name     string
+	Required bool
+}
+
+// ConsulConfig holds the values of a fixed set of Consul keys. Load reads
+// them once and Run keeps them current with blocking queries, so readers
+// never wait on Consul.
+type ConsulConfig struct {
+	kv     KV
+	keys   []ConsulKey
+	logger log.Logger
+	mu     sync.RWMutex
+	values map[string]string
+	index  map[string]uint64
+}
+
+// NewConsulConfig creates a config for keys read from kv.
+func NewConsulConfig(kv KV, logger log.Logger, keys ...ConsulKey) *ConsulConfig {
+	return &ConsulConfig{
+		kv:     kv,
+		keys:   keys,
+		logger: log.With(logger, "component", "consulConfig"),
+		values: map[string]string{},
+		index:  map[string]uint64{},
+	}
+}
+
+// Load reads every key once. It fails if Consul cannot be reached or a
+// required key is missing or empty.
+func (c *ConsulConfig) Load(ctx context.Context) error {
+	missing := []string{}
+	for _, key := range c.keys {
+		pair, meta, err := c.kv.Get(key.Name, (&consulapi.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("reading consul key %s: %w", key.Name, err)
+		}
+		c.set(key.Name, pair, meta.LastIndex)
+		if _, ok := c.Lookup(key.Name); key.Required && !ok {
+			missing = append(missing, key.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required consul keys not set: %v", missing)
+	}
+	return nil
+}
+
+// Run watches every key until ctx is done.
+func (c *ConsulConfig) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, key := range c.keys {
+		wg.Add(1)
+		go func(key ConsulKey) {
+			defer wg.Done()
+			c.watch(ctx, key)
+		}(key)
+	}
+	wg.Wait()
+}
+
+func (c *ConsulConfig) watch(ctx context.Context, key ConsulKey) {
+	backoff := time.Second
+	for {
+		c.mu.RLock()
+		index := c.index[key.Name]
+		c.mu.RUnlock()
+		pair, meta, err := c.kv.Get(key.Name, (&consulapi.QueryOptions{WaitIndex: index, WaitTime: consulWait}).WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			level.Error(c.logger).Log("msg", "watching consul key", "key", key.Name, "err", err)
+			if !sleepBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+		backoff = time.Second
+		if pair == nil && key.Required {
+			// Keep serving the last value rather than failing requests.
+			level.Warn(c.logger).Log("msg", "required consul key removed, keeping last value", "key", key.Name)
+			pair = &consulapi.KVPair{Key: key.Name, Value: []byte(c.String(key.Name))}
+		}
+		c.set(key.Name, pair, nextWaitIndex(index, meta))
+	}
+}
+
+// sleepBackoff waits for *backoff and doubles it up to a minute. It
+// returns false if ctx is done first.
+func sleepBackoff(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*backoff):
+	}
+	if *backoff < time.Minute {
+		*backoff *= 2
+	}
+	return true
+}
+
+// nextWaitIndex returns the index for the next blocking query, starting
+// over if Consul's index went backwards, e.g. after a snapshot restore.
+func nextWaitIndex(previous uint64, meta *consulapi.QueryMeta) uint64 {
+	if meta.LastIndex < previous {
+		return 0
+	}
+	return meta.LastIndex
+}
+
+func (c *ConsulConfig) set(key string, pair *consulapi.KVPair, index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index[key] = index
+	if pair == nil || len(pair.Value) == 0 {
+		delete(c.values, key)
+		return
+	}
+	if value := string(pair.Value); c.values[key] != value {
+		c.values[key] = value
+		c.logger.Log("msg", "consul key updated", "key", key)
+	}
+}
+
+// Lookup returns the value of key and whether it is set.
+func (c *ConsulConfig) Lookup(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// String returns the value of key, or "" if it is not set.
+func (c *ConsulConfig) String(key string) string {
+	value, _ := c.Lookup(key)
+	return value
+}
+
+// Int returns key parsed as an integer, or fallback.
+func (c *ConsulConfig) Int(key string, fallback int) int {
+	if n, err := strconv.Atoi(c.String(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// Float returns key parsed as a float, or fallback.
+func (c *ConsulConfig) Float(key string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(c.String(key), 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
+// Bool returns key parsed as a boolean, or fallback.
+func (c *ConsulConfig) Bool(key string, fallback bool) bool {
+	if b, err := strconv.ParseBool(c.String(key)); err == nil {
+		return b
+	}
+	return fallback
+}
+
+// Duration returns key parsed as a duration such as "90s", or fallback.
+func (c *ConsulConfig) Duration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(c.String(key)); err == nil {
+		return d
+	}
+	return fallback
+}
+
+// MemoryKV is an in-memory KV for tests. Get honours blocking queries.
+type MemoryKV struct {
+	mu      sync.Mutex
+	index   uint64
+	pairs   map[string][]byte
+	changed chan struct{}
+}
+
+// NewMemoryKV returns an empty MemoryKV.
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{index: 1, pairs: map[string][]byte{}, changed: make(chan struct{})}
+}
+
+// Put sets key and wakes blocked queries.
+func (kv *MemoryKV) Put(key string, value string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.pairs[key] = []byte(value)
+	kv.bump()
+}
+
+// Delete removes key and wakes blocked queries.
+func (kv *MemoryKV) Delete(key string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.pairs, key)
+	kv.bump()
+}
+
+func (kv *MemoryKV) bump() {
+	kv.index++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *MemoryKV) Get(key string, q *consulapi.QueryOptions) (*consulapi.KVPair, *consulapi.QueryMeta, error) {
+	if q == nil {
+		q = &consulapi.QueryOptions{}
+	}
+	var timeout <-chan time.Time
+	if q.WaitIndex > 0 {
+		wait := q.WaitTime
+		if wait <= 0 {
+			wait = consulWait
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		kv.mu.Lock()
+		index, changed := kv.index, kv.changed
+		value, ok := kv.pairs[key]
+		kv.mu.Unlock()
+		if index > q.WaitIndex || timeout == nil {
+			meta := &consulapi.QueryMeta{LastIndex: index}
+			if !ok {
+				return nil, meta, nil
+			}
+			return &consulapi.KVPair{Key: key, Value: value, ModifyIndex: index}, meta, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			timeout = nil
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package reservations
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsulConfigLoad(t *testing.T) {
+	kv := NewMemoryKV()
+	kv.Put("limit", "7")
+	config := NewConsulConfig(kv, log.NewNopLogger(),
+		ConsulKey{Name: KeyChargersService, Required: true},
+		ConsulKey{Name: "limit"},
+	)
+	err := config.Load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), KeyChargersService) {
+		t.Fatalf("missing required key: %v", err)
+	}
+	kv.Put(KeyChargersService, "http://chargers:8080")
+	if err := config.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.String(KeyChargersService); got != "http://chargers:8080" {
+		t.Errorf("got %q", got)
+	}
+	if got := config.Int("limit", 1); got != 7 {
+		t.Errorf("got limit %d", got)
+	}
+	if got := config.Duration(KeyChargersService, time.Second); got != time.Second {
+		t.Errorf("malformed value should fall back, got %v", got)
+	}
+	if _, ok := config.Lookup("unknown"); ok {
+		t.Error("unknown key reported as set")
+	}
+}
+
+func TestConsulConfigWatch(t *testing.T) {
+	kv := NewMemoryKV()
+	kv.Put(KeyJWTSecret, "first")
+	kv.Put("feature", "true")
+	config := NewConsulConfig(kv, log.NewNopLogger(),
+		ConsulKey{Name: KeyJWTSecret, Required: true},
+		ConsulKey{Name: "feature"},
+	)
+	if err := config.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		config.Run(ctx)
+		close(done)
+	}()
+
+	kv.Put(KeyJWTSecret, "second")
+	waitFor(t, "secret update", func() bool { return config.String(KeyJWTSecret) == "second" })
+	secret := NewConsulSecret(config, KeyJWTSecret)
+	if key, err := secret.Key("", "HS256"); err != nil || string(key.([]byte)) != "second" {
+		t.Errorf("got key %v, %v", key, err)
+	}
+
+	kv.Delete("feature")
+	waitFor(t, "optional key removal", func() bool { return !config.Bool("feature", false) })
+	kv.Delete(KeyJWTSecret)
+	kv.Put("other", "x")
+	time.Sleep(20 * time.Millisecond)
+	if got := config.String(KeyJWTSecret); got != "second" {
+		t.Errorf("required key should keep its last value, got %q", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+}
@@ -1,56 +1,48 @@
 package reservations
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"math"
-	"net/http"
+	"errors"
 	"time"
 
 	"github.com/go-kit/log"
-	consulapi "github.com/hashicorp/consul/api"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type database struct {
-	db     *mongo.Database
-	logger log.Logger
-	consul consulapi.Client
+	db              *mongo.Database
+	logger          log.Logger
+	chargers        *ChargerIndex
+	maxSearchRadius float64
 }
 
-func NewDatabase(db *mongo.Database, logger log.Logger, consul consulapi.Client) ReservationDB {
+func NewDatabase(db *mongo.Database, logger log.Logger, chargers *ChargerIndex, maxSearchRadius float64) ReservationDB {
 	return &database{
-		db:     db,
-		logger: log.With(logger, "database", "mongoDB"),
-		consul: consul,
+		db:              db,
+		logger:          log.With(logger, "database", "mongoDB"),
+		chargers:        chargers,
+		maxSearchRadius: maxSearchRadius,
 	}
 }
 
-func (dat *database) CreateReservation(ctx context.Context, from string, to string, userID string, chargerID string) error {
+func (dat *database) CreateReservation(ctx context.Context, from time.Time, to time.Time, userID string, chargerID string) error {
 	userIDmongo, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		dat.logger.Log("Error creating reservation: ", err.Error())
-		return err
+		return invalidID("userID", userID)
 	}
 	chargerIDmongo, err := primitive.ObjectIDFromHex(chargerID)
 	if err != nil {
-		dat.logger.Log("Error creating rating: ", err.Error())
-		return err
-	}
-	reservationObj := Reservation{
-		ChargerID: chargerIDmongo,
-		UserID:    userIDmongo,
-		From:      from,
-		To:        to,
-		Created:   time.Now().Format(time.RFC3339),
-		Modified:  time.Now().Format(time.RFC3339),
+		dat.logger.Log("Error creating reservation: ", err.Error())
+		return invalidID("chargerID", chargerID)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	reservationObj := newReservation(userIDmongo, chargerIDmongo, from, to, time.Now().UTC())
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	_, err = dat.db.Collection("Reservations").InsertOne(ctx, reservationObj)
+	_, err = dat.insertReservation(ctx, reservationObj)
 	if err != nil {
 		dat.logger.Log("Error inserting reservation into DB: ", err.Error())
 		return err
@@ -63,40 +55,99 @@ func (dat *database) GetReservation(ctx context.Context, id string) (Reservation
 
 	if err != nil {
 		dat.logger.Log("Error getting reservation from DB: ", err.Error())
-		return tempReservation, err
+		return tempReservation, invalidID("id", id)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	err = dat.db.Collection("Reservations").FindOne(ctx, bson.M{"_id": objectID}).Decode(&tempReservation)
+	if err == mongo.ErrNoDocuments {
+		return tempReservation, ErrReservationNotFound
+	}
 	if err != nil {
 		dat.logger.Log("Error getting reservation from DB: ", err.Error())
 		return tempReservation, err
 	}
 	return tempReservation, nil
 }
-func (dat *database) DeleteReservation(ctx context.Context, id string) error {
+func (dat *database) DeleteReservation(ctx context.Context, id string, expectedVersion int64) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		dat.logger.Log("Error deleting reservation from DB: ", err.Error())
-		return err
+		return invalidID("id", id)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	filter := bson.M{"_id": objectID}
-	res := dat.db.Collection("Reservations").FindOneAndDelete(ctx, filter)
-	if res.Err() == mongo.ErrNoDocuments {
+	res, err := dat.db.Collection("Reservations").DeleteOne(ctx, versionFilter(objectID, expectedVersion))
+	if err != nil {
 		dat.logger.Log("Error deleting reservation from DB: ", err.Error())
 		return err
 	}
+	if res.DeletedCount == 0 {
+		return dat.missedVersion(ctx, objectID)
+	}
 	return nil
 }
-func (dat *database) GetReservations(ctx context.Context) ([]Reservation, error) {
+func (dat *database) GetReservations(ctx context.Context, states []ReservationState) ([]Reservation, error) {
+	return dat.GetReservationsFilter(ctx, ReservationFilter{States: states})
+}
+func (dat *database) UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersion int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		dat.logger.Log("Error updating reservation: ", err.Error())
+		return invalidID("id", id)
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"from":     from,
+			"to":       to,
+			"modified": time.Now().UTC(),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	current := Reservation{}
+	err = dat.db.Collection("Reservations").FindOne(ctx, bson.M{"_id": objectID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return ErrReservationNotFound
+	}
+	if err != nil {
+		dat.logger.Log("Error updating reservation: ", err.Error())
+		return err
+	}
+	err = dat.withChargerLock(ctx, current.ChargerID, func(sc mongo.SessionContext) error {
+		if err := dat.checkOverlap(sc, current.ChargerID, from, to, objectID); err != nil {
+			return err
+		}
+		res, err := dat.db.Collection("Reservations").UpdateOne(sc, versionFilter(objectID, expectedVersion), update)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return dat.missedVersion(sc, objectID)
+		}
+		return nil
+	})
+	if err != nil {
+		dat.logger.Log("Error updating reservation: ", err.Error())
+		return err
+	}
+
+	return nil
+}
+func (dat *database) GetReservationsFilter(ctx context.Context, filter ReservationFilter) ([]Reservation, error) {
 	tempReservation := Reservation{}
 	tempReservations := []Reservation{}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	query, err := filterToBSON(filter)
+	if err != nil {
+		dat.logger.Log("Error getting reservations from DB: ", err.Error())
+		return tempReservations, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	cursor, err := dat.db.Collection("Reservations").Find(ctx, bson.D{})
+	cursor, err := dat.db.Collection("Reservations").Find(ctx, query)
 	if err != nil {
 		dat.logger.Log("Error getting reservations from DB: ", err.Error())
 		return tempReservations, err
@@ -105,170 +156,437 @@ func (dat *database) GetReservations(ctx context.Context) ([]Reservation, error)
 	for cursor.Next(ctx) {
 		err := cursor.Decode(&tempReservation)
 		if err != nil {
-			dat.logger.Log("Error getting reservation from DB: ", err.Error())
+			dat.logger.Log("Error getting reservations from DB: ", err.Error())
 			return tempReservations, err
 		}
 		tempReservations = append(tempReservations, tempReservation)
 	}
 	return tempReservations, nil
 }
-func (dat *database) UpdateReservation(ctx context.Context, id string, from string, to string) error {
+func (dat *database) GetReservationsPage(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error) {
+	result := ReservationPage{Reservations: []Reservation{}}
+	page = page.normalize()
+	query, err := filterToBSON(filter)
+	if err != nil {
+		return result, err
+	}
+	cursor, err := page.cursor()
+	if err != nil {
+		return result, err
+	}
+	// Keyset pagination compares sort keys as dates, while MongoDB orders
+	// any strings before all dates. Timestamps still stored as strings, which
+	// migration 0002_reservation_dates converts, would be skipped or
+	// repeated, so paged listings leave them out.
+	conditions := bson.A{query, bson.M{string(page.Sort): bson.M{"$type": "date"}}}
+	if cursor != nil {
+		conditions = append(conditions, cursorFilter(*cursor))
+	}
+	query = bson.M{"$and": conditions}
+	direction := 1
+	if page.Descending {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: string(page.Sort), Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(page.Limit + 1))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	found, err := dat.db.Collection("Reservations").Find(ctx, query, opts)
+	if err != nil {
+		dat.logger.Log("Error getting reservations from DB: ", err.Error())
+		return result, err
+	}
+	defer found.Close(ctx)
+	reservations := []Reservation{}
+	for found.Next(ctx) {
+		reservation := Reservation{}
+		if err := found.Decode(&reservation); err != nil {
+			dat.logger.Log("Error getting reservations from DB: ", err.Error())
+			return result, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	if err := found.Err(); err != nil {
+		return result, err
+	}
+	return pageOf(reservations, page), nil
+}
+func (dat *database) UpdateReservationState(ctx context.Context, id string, expected ReservationState, transition StateTransition) (Reservation, error) {
+	tempReservation := Reservation{}
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		dat.logger.Log("Error updating reservation: ", err.Error())
-		return err
+		dat.logger.Log("Error updating reservation state: ", err.Error())
+		return tempReservation, invalidID("id", id)
+	}
+	filter := bson.M{"_id": objectID, "state": expected}
+	if expected == StatePending {
+		// Reservations created before the lifecycle existed have no state.
+		filter["state"] = bson.M{"$in": bson.A{StatePending, nil}}
 	}
 	update := bson.M{
 		"$set": bson.M{
-			"from":     from,
-			"to":       to,
-			"modified": time.Now().Format(time.RFC3339),
+			"state":    transition.To,
+			"modified": transition.At,
 		},
+		"$push": bson.M{"history": transition},
+		"$inc":  bson.M{"version": 1},
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	_, err = dat.db.Collection("Reservations").UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = dat.db.Collection("Reservations").FindOneAndUpdate(ctx, filter, update, opts).Decode(&tempReservation)
+	if err == mongo.ErrNoDocuments {
+		// Either the reservation is gone or another request moved it first.
+		if _, err := dat.GetReservation(ctx, id); err != nil {
+			return tempReservation, err
+		}
+		return tempReservation, ErrIllegalTransition
+	}
 	if err != nil {
-		dat.logger.Log("Error updating reservation: ", err.Error())
-		return err
+		dat.logger.Log("Error updating reservation state: ", err.Error())
+		return tempReservation, err
 	}
-
-	return nil
+	return tempReservation, nil
 }
-func (dat *database) GetReservationsFilter(ctx context.Context, chargerID string, userID string) ([]Reservation, error) {
-	var mchargerID primitive.ObjectID
-	var muserID primitive.ObjectID
-	var err error
-	filter := bson.M{}
+func (dat *database) ReservationClosest(ctx context.Context, userID string, from time.Time, to time.Time, location Location) (Reservation, error) {
 	tempReservation := Reservation{}
-	tempReservations := []Reservation{}
-	if chargerID != "" {
-		mchargerID, err = primitive.ObjectIDFromHex(chargerID)
-		if err != nil {
-			dat.logger.Log("Error getting reservations from DB: ", err.Error())
-			return tempReservations, err
-		}
+	userIDmongo, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		dat.logger.Log("Error creating reservation: ", err.Error())
+		return tempReservation, invalidID("userID", userID)
 	}
-	if userID != "" {
-		muserID, err = primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			dat.logger.Log("Error getting reservations from DB: ", err.Error())
-			return tempReservations, err
-		}
+	reservationObj := newReservation(userIDmongo, primitive.NilObjectID, from, to, time.Now().UTC())
+	insertCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	reservation, err := bookClosest(ctx, dat.chargers, location, dat.maxSearchRadius, reservationObj, func(r Reservation) (primitive.ObjectID, error) {
+		return dat.insertReservation(insertCtx, r)
+	})
+	if err != nil && !errors.Is(err, ErrNoChargerAvailable) {
+		dat.logger.Log("Error booking the closest charger: ", err.Error())
 	}
+	return reservation, err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (dat *database) FindChargers(ctx context.Context, from time.Time, to time.Time, location Location) ([]ChargerCandidate, error) {
+	nearby, err := dat.chargers.WithinRadius(ctx, location, dat.maxSearchRadius)
+	if err != nil {
+		dat.logger.Log("Error getting chargers: ", err.Error())
+		return nil, err
+	}
+	ids := bson.A{}
+	for _, candidate := range nearby {
+		ids = append(ids, candidate.Charger.ID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	if userID != "" && chargerID == "" {
-		filter = bson.M{"userid": muserID}
-	} else if userID == "" && chargerID != "" {
-		filter = bson.M{"chargerid": mchargerID}
-	} else if userID != "" && chargerID != "" {
-		filter = bson.M{"chargerid": mchargerID, "userid": muserID}
+	busy := map[primitive.ObjectID]bool{}
+	filter := bson.M{
+		"chargerid": bson.M{"$in": ids},
+		"state":     bson.M{"$nin": releasedStates},
+		"from":      bson.M{"$lt": to},
+		"to":        bson.M{"$gt": from},
 	}
 	cursor, err := dat.db.Collection("Reservations").Find(ctx, filter)
 	if err != nil {
 		dat.logger.Log("Error getting reservations from DB: ", err.Error())
-		return tempReservations, err
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 	for cursor.Next(ctx) {
-		err := cursor.Decode(&tempReservation)
-		if err != nil {
+		reservation := Reservation{}
+		if err := cursor.Decode(&reservation); err != nil {
 			dat.logger.Log("Error getting reservations from DB: ", err.Error())
-			return tempReservations, err
+			return nil, err
 		}
-		tempReservations = append(tempReservations, tempReservation)
+		busy[reservation.ChargerID] = true
 	}
-	return tempReservations, nil
+	return chargerCandidates(nearby, busy), nil
 }
-func (dat *database) ReservationClosest(ctx context.Context, userID string, from string, to string, location Location) (Reservation, error) {
-	tempReservation := Reservation{}
-	userIDmongo, err := primitive.ObjectIDFromHex(userID)
+
+func (dat *database) CreateSeries(ctx context.Context, series ReservationSeries, occurrences []Interval) (ReservationSeries, []OccurrenceResult, error) {
+	results := []OccurrenceResult{}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	res, err := dat.db.Collection("ReservationSeries").InsertOne(ctx, series)
 	if err != nil {
-		dat.logger.Log("Error creating reservation: ", err.Error())
-		return tempReservation, err
+		dat.logger.Log("Error inserting reservation series into DB: ", err.Error())
+		return series, results, err
+	}
+	series.ID, _ = res.InsertedID.(primitive.ObjectID)
+	for _, occurrence := range occurrences {
+		reservationObj := newReservation(series.UserID, series.ChargerID, occurrence.From, occurrence.To, series.Created)
+		reservationObj.SeriesID = series.ID
+		result := OccurrenceResult{From: occurrence.From, To: occurrence.To, Status: OccurrenceBooked}
+		id, err := dat.insertReservation(ctx, reservationObj)
+		switch {
+		case errors.Is(err, ErrReservationConflict):
+			result.Status = OccurrenceConflict
+			result.Error = err.Error()
+		case err != nil:
+			dat.logger.Log("Error inserting reservation into DB: ", err.Error())
+			return series, results, err
+		default:
+			result.ReservationID = id.Hex()
+		}
+		results = append(results, result)
 	}
-	requestBody, _ := json.Marshal(GetChargersRequest{})
-	client := &http.Client{}
-	chargersAddr, _ := getConsulValue(dat.consul, dat.logger, "chargersService")
-	chargersUri := chargersAddr + "/chargers"
-	req, err := http.NewRequest(http.MethodGet, chargersUri, bytes.NewBuffer(requestBody))
+	return series, results, nil
+}
+
+func (dat *database) MoveSeries(ctx context.Context, id string, shift time.Duration, length time.Duration) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return tempReservation, err
+		return invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	series := ReservationSeries{}
+	err = dat.db.Collection("ReservationSeries").FindOne(ctx, bson.M{"_id": objectID}).Decode(&series)
+	if err == mongo.ErrNoDocuments {
+		return ErrSeriesNotFound
 	}
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	resp, err := client.Do(req)
 	if err != nil {
-		return tempReservation, err
+		dat.logger.Log("Error moving reservation series: ", err.Error())
+		return err
+	}
+	from := series.From.Add(shift)
+	update := bson.M{"$set": bson.M{"from": from, "to": from.Add(length)}}
+	if _, err := dat.db.Collection("ReservationSeries").UpdateOne(ctx, bson.M{"_id": objectID}, update); err != nil {
+		dat.logger.Log("Error moving reservation series: ", err.Error())
+		return err
 	}
-	defer resp.Body.Close()
-	tempResponse := GetChargersResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&tempResponse)
+	return nil
+}
+
+func (dat *database) SplitSeries(ctx context.Context, id string, at time.Time, shift time.Duration, length time.Duration, occurrences []string) (ReservationSeries, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return tempReservation, err
+		return ReservationSeries{}, invalidID("id", id)
 	}
-	client.CloseIdleConnections()
-	tmpChar, _ := getClosestCharger(location, tempResponse.Chargers)
-	reservationObj := Reservation{
-		ChargerID: tmpChar.ID,
-		UserID:    userIDmongo,
-		From:      from,
-		To:        to,
-		Created:   time.Now().Format(time.RFC3339),
-		Modified:  time.Now().Format(time.RFC3339),
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	occurrenceIDs := bson.A{}
+	for _, occurrence := range occurrences {
+		if occurrenceID, err := primitive.ObjectIDFromHex(occurrence); err == nil {
+			occurrenceIDs = append(occurrenceIDs, occurrenceID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	_, err = dat.db.Collection("Reservations").InsertOne(ctx, reservationObj)
+	series := ReservationSeries{}
+	err = dat.db.Collection("ReservationSeries").FindOne(ctx, bson.M{"_id": objectID}).Decode(&series)
+	if err == mongo.ErrNoDocuments {
+		return series, ErrSeriesNotFound
+	}
 	if err != nil {
-		dat.logger.Log("Error inserting reservation into DB: ", err.Error())
-		return tempReservation, err
+		dat.logger.Log("Error splitting reservation series: ", err.Error())
+		return series, err
 	}
-	tempReservation.ChargerID = tmpChar.ID
-	tempReservation.UserID = userIDmongo
-	tempReservation.From = from
-	tempReservation.To = to
-	tempReservation.Created = reservationObj.Created
-	tempReservation.Modified = reservationObj.Modified
-	return tempReservation, nil
+	ended, following, err := splitSeries(series, at, shift, length)
+	if err != nil {
+		return following, err
+	}
+	following.ID = primitive.NewObjectID()
+	if _, err := dat.db.Collection("ReservationSeries").InsertOne(ctx, following); err != nil {
+		dat.logger.Log("Error splitting reservation series: ", err.Error())
+		return following, err
+	}
+	if _, err := dat.db.Collection("ReservationSeries").UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": bson.M{"rrule": ended.RRule}}); err != nil {
+		dat.logger.Log("Error splitting reservation series: ", err.Error())
+		return following, err
+	}
+	_, err = dat.db.Collection("Reservations").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": occurrenceIDs}, "seriesid": objectID},
+		bson.M{
+			"$set": bson.M{"seriesid": following.ID, "modified": following.Created},
+			"$inc": bson.M{"version": 1},
+		})
+	if err != nil {
+		dat.logger.Log("Error splitting reservation series: ", err.Error())
+		return following, err
+	}
+	return following, nil
 }
-func getClosestCharger(location Location, chargers []Charger) (Charger, error) {
-	closest := Charger{}
-	minDist := 100000.0
-	for _, charger := range chargers {
-		dst, _ := calcDistance(location, charger.Location)
-		if dst < minDist {
-			closest = charger
-			minDist = dst
+
+// insertReservation stores reservation unless it overlaps another booking on
+// the same charger. The check and the insert run in one transaction guarded by
+// the charger lock, so it holds across replicas as well.
+func (dat *database) insertReservation(ctx context.Context, reservation Reservation) (primitive.ObjectID, error) {
+	var id primitive.ObjectID
+	err := dat.withChargerLock(ctx, reservation.ChargerID, func(sc mongo.SessionContext) error {
+		if err := dat.checkOverlap(sc, reservation.ChargerID, reservation.From, reservation.To, primitive.NilObjectID); err != nil {
+			return err
+		}
+		res, err := dat.db.Collection("Reservations").InsertOne(sc, reservation)
+		if err != nil {
+			return err
 		}
+		id, _ = res.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	return id, err
+}
+
+// withChargerLock runs fn in a transaction that first bumps the lock document
+// of chargerID. Two transactions touching the same charger therefore always
+// write-conflict, and the driver retries the loser against the fresh state.
+func (dat *database) withChargerLock(ctx context.Context, chargerID primitive.ObjectID, fn func(sc mongo.SessionContext) error) error {
+	session, err := dat.db.Client().StartSession()
+	if err != nil {
+		return err
 	}
-	return closest, nil
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		_, err := dat.db.Collection("ChargerLocks").UpdateOne(sc,
+			bson.M{"_id": chargerID},
+			bson.M{"$inc": bson.M{"seq": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(sc)
+	})
+	return err
 }
-func getConsulValue(consul consulapi.Client, logger log.Logger, key string) (string, error) {
-	kv := consul.KV()
-	keyPair, _, err := kv.Get(key, nil)
+
+// checkOverlap returns ErrReservationConflict if any reservation on chargerID
+// other than exclude intersects [from, to).
+func (dat *database) checkOverlap(ctx context.Context, chargerID primitive.ObjectID, from time.Time, to time.Time, exclude primitive.ObjectID) error {
+	// Documents still holding string timestamps do not compare against
+	// dates, so they are fetched as well and checked after decoding.
+	filter := bson.M{
+		"chargerid": chargerID,
+		"_id":       bson.M{"$ne": exclude},
+		"state":     bson.M{"$nin": releasedStates},
+		"$or": bson.A{
+			bson.M{"from": bson.M{"$lt": to}, "to": bson.M{"$gt": from}},
+			bson.M{"from": bson.M{"$type": "string"}},
+		},
+	}
+	cursor, err := dat.db.Collection("Reservations").Find(ctx, filter)
 	if err != nil {
-		logger.Log("msg", "Failed getting consul key")
-		return "", err
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		other := Reservation{}
+		if err := cursor.Decode(&other); err != nil {
+			return err
+		}
+		if overlaps(from, to, other.From, other.To) {
+			return ErrReservationConflict
+		}
 	}
-	return string(keyPair.Value), nil
+	return cursor.Err()
 }
-func calcDistance(loc1 Location, loc2 Location) (float64, error) {
-	radlat1 := float64(math.Pi * loc1.Latitude / 180)
-	radlat2 := float64(math.Pi * loc2.Latitude / 180)
-	theta := float64(loc1.Longitude - loc2.Longitude)
-	radtheta := float64(math.Pi * theta / 180)
 
-	dist := math.Sin(radlat1)*math.Sin(radlat2) + math.Cos(radlat1)*math.Cos(radlat2)*math.Cos(radtheta)
+// versionFilter matches the reservation id, provided its version is still
+// expected. Documents written before versioning have no version field and
+// count as version 0.
+func versionFilter(id primitive.ObjectID, expected int64) bson.M {
+	filter := bson.M{"_id": id}
+	switch {
+	case expected == AnyVersion:
+	case expected == 0:
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	default:
+		filter["version"] = expected
+	}
+	return filter
+}
 
-	if dist > 1 {
-		dist = 1
+// missedVersion explains why a versioned write matched nothing: either the
+// reservation is gone or its version has moved on.
+func (dat *database) missedVersion(ctx context.Context, id primitive.ObjectID) error {
+	err := dat.db.Collection("Reservations").FindOne(ctx, bson.M{"_id": id}).Err()
+	if err == mongo.ErrNoDocuments {
+		return ErrReservationNotFound
+	}
+	if err != nil {
+		return err
 	}
+	return ErrVersionMismatch
+}
 
-	dist = math.Acos(dist)
-	dist = dist * 180 / math.Pi
-	dist = dist * 60 * 1.1515 * 1.60934
+// cursorFilter matches the reservations that sort after cursor.
+func cursorFilter(cursor pageCursor) bson.M {
+	op := "$gt"
+	if cursor.Descending {
+		op = "$lt"
+	}
+	field := string(cursor.Sort)
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: cursor.Value}},
+		bson.M{field: cursor.Value, "_id": bson.M{op: cursor.ID}},
+	}}
+}
 
-	return dist, nil
+// filterToBSON translates a ReservationFilter into a Mongo query.
+func filterToBSON(filter ReservationFilter) (bson.M, error) {
+	query := bson.M{}
+	if filter.ChargerID != "" {
+		chargerID, err := primitive.ObjectIDFromHex(filter.ChargerID)
+		if err != nil {
+			return nil, invalidID("charger", filter.ChargerID)
+		}
+		query["chargerid"] = chargerID
+	}
+	if len(filter.ChargerIDs) > 0 {
+		chargerIDs := bson.A{}
+		for _, id := range filter.ChargerIDs {
+			chargerID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, invalidID("charger", id)
+			}
+			chargerIDs = append(chargerIDs, chargerID)
+		}
+		if filter.ChargerID != "" {
+			// Both set: the single charger must also be in the list.
+			query["$and"] = bson.A{bson.M{"chargerid": bson.M{"$in": chargerIDs}}}
+		} else {
+			query["chargerid"] = bson.M{"$in": chargerIDs}
+		}
+	}
+	if filter.UserID != "" {
+		userID, err := primitive.ObjectIDFromHex(filter.UserID)
+		if err != nil {
+			return nil, invalidID("user", filter.UserID)
+		}
+		query["userid"] = userID
+	}
+	if filter.SeriesID != "" {
+		seriesID, err := primitive.ObjectIDFromHex(filter.SeriesID)
+		if err != nil {
+			return nil, invalidID("series", filter.SeriesID)
+		}
+		query["seriesid"] = seriesID
+	}
+	if len(filter.States) > 0 {
+		states := bson.A{}
+		for _, state := range filter.States {
+			states = append(states, state)
+			if state == StatePending {
+				states = append(states, nil)
+			}
+		}
+		query["state"] = bson.M{"$in": states}
+	}
+	if !filter.To.IsZero() {
+		query["from"] = bson.M{"$lt": filter.To}
+	}
+	if !filter.From.IsZero() {
+		query["to"] = bson.M{"$gt": filter.From}
+	}
+	created := bson.M{}
+	if !filter.CreatedFrom.IsZero() {
+		created["$gte"] = filter.CreatedFrom
+	}
+	if !filter.CreatedTo.IsZero() {
+		created["$lt"] = filter.CreatedTo
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+	return query, nil
 }
@@ -0,0 +1,98 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestMongoDatabase runs the conformance suite against the Mongo deployment
+// in RESERVATIONS_TEST_MONGO_URI. Bookings use transactions, so it must be a
+// replica set; every subtest works in a throwaway database.
+func TestMongoDatabase(t *testing.T) {
+	client := connectTestMongo(t)
+	testReservationDB(t, func(t *testing.T, chargers *ChargerIndex) ReservationDB {
+		return NewDatabase(throwawayMongo(t, client), log.NewNopLogger(), chargers, conformanceRadius)
+	})
+}
+
+func TestMongoDatabaseRequestContext(t *testing.T) {
+	db := NewDatabase(throwawayMongo(t, connectTestMongo(t)), log.NewNopLogger(), newTestIndex(nil), conformanceRadius)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.GetReservationsFilter(ctx, ReservationFilter{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("listing with a cancelled request: %v", err)
+	}
+	if err := db.CreateReservation(ctx, hour(0), hour(1), primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()); !errors.Is(err, context.Canceled) {
+		t.Errorf("booking with a cancelled request: %v", err)
+	}
+}
+
+func TestMongoDatabasePageSkipsStringDates(t *testing.T) {
+	mongoDB := throwawayMongo(t, connectTestMongo(t))
+	db := NewDatabase(mongoDB, log.NewNopLogger(), newTestIndex(nil), conformanceRadius)
+	ctx := context.Background()
+	user, charger := primitive.NewObjectID(), primitive.NewObjectID()
+	for h := 0; h < 3; h++ {
+		mustCreate(t, db, user, charger, hour(2*h), hour(2*h+1))
+	}
+	legacy := bson.M{"_id": primitive.NewObjectID(), "userid": user, "chargerid": charger, "from": hour(1).Format(time.RFC3339), "to": hour(2).Format(time.RFC3339)}
+	if _, err := mongoDB.Collection("Reservations").InsertOne(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	page := PageRequest{Limit: 1}
+	for {
+		result, err := db.GetReservationsPage(ctx, ReservationFilter{UserID: user.Hex()}, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range result.Reservations {
+			if seen[r.ID] {
+				t.Errorf("reservation %s listed twice", r.ID.Hex())
+			}
+			seen[r.ID] = true
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		page.Cursor = result.NextCursor
+	}
+	if len(seen) != 3 || seen[legacy["_id"].(primitive.ObjectID)] {
+		t.Errorf("paged through %d reservations: %v", len(seen), seen)
+	}
+}
+
+// connectTestMongo connects to RESERVATIONS_TEST_MONGO_URI, or skips the
+// test when it is not set.
+func connectTestMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv("RESERVATIONS_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("RESERVATIONS_TEST_MONGO_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+// throwawayMongo returns a new database that is dropped when t ends.
+func throwawayMongo(t *testing.T, client *mongo.Client) *mongo.Database {
+	db := client.Database("ReservationsTest_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
@@ -0,0 +1,131 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// DefaultChargersService is the Consul service name of the chargers service.
+const DefaultChargersService = "chargers"
+
+var errNoInstances = errors.New("no healthy instances and no fallback address")
+
+// HealthAPI is the part of the Consul health API that ServiceResolver uses.
+// *consulapi.Health satisfies it.
+type HealthAPI interface {
+	Service(service, tag string, passingOnly bool, q *consulapi.QueryOptions) ([]*consulapi.ServiceEntry, *consulapi.QueryMeta, error)
+}
+
+// ServiceResolver keeps the passing instances of a Consul service and hands
+// out their base URLs round-robin. While no instance is passing it falls
+// back to a fixed address, such as the one in the chargersService key.
+type ServiceResolver struct {
+	// next is first to keep it 64-bit aligned for atomic access.
+	next      uint64
+	health    HealthAPI
+	service   string
+	fallback  func() (string, bool)
+	logger    log.Logger
+	mu        sync.RWMutex
+	instances []string
+	index     uint64
+}
+
+// NewServiceResolver creates a resolver for service. fallback may be nil.
+func NewServiceResolver(health HealthAPI, service string, fallback func() (string, bool), logger log.Logger) *ServiceResolver {
+	return &ServiceResolver{
+		health:   health,
+		service:  service,
+		fallback: fallback,
+		logger:   log.With(logger, "component", "resolver", "service", service),
+	}
+}
+
+// Refresh reloads the passing instances once.
+func (r *ServiceResolver) Refresh(ctx context.Context) error {
+	return r.query(ctx, 0)
+}
+
+// Run keeps the instance list current with blocking queries until ctx is
+// done.
+func (r *ServiceResolver) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		r.mu.RLock()
+		index := r.index
+		r.mu.RUnlock()
+		err := r.query(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			level.Error(r.logger).Log("msg", "watching service instances", "err", err)
+			if !sleepBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+		backoff = time.Second
+	}
+}
+
+func (r *ServiceResolver) query(ctx context.Context, index uint64) error {
+	q := &consulapi.QueryOptions{WaitIndex: index, WaitTime: consulWait}
+	entries, meta, err := r.health.Service(r.service, "", true, q.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	instances := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+		address := entry.Service.Address
+		if address == "" && entry.Node != nil {
+			address = entry.Node.Address
+		}
+		instances = append(instances, "http://"+net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(instances) != len(r.instances) {
+		r.logger.Log("msg", "service instances changed", "passing", len(instances))
+	}
+	r.instances = instances
+	r.index = nextWaitIndex(index, meta)
+	return nil
+}
+
+// Instances returns the base URLs of the passing instances.
+func (r *ServiceResolver) Instances() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.instances...)
+}
+
+// Next returns the base URL to send the next request to.
+func (r *ServiceResolver) Next() (string, error) {
+	r.mu.RLock()
+	instances := r.instances
+	r.mu.RUnlock()
+	if len(instances) > 0 {
+		n := atomic.AddUint64(&r.next, 1)
+		return instances[(n-1)%uint64(len(instances))], nil
+	}
+	if r.fallback != nil {
+		if address, ok := r.fallback(); ok {
+			return address, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", r.service, errNoInstances)
+}
@@ -0,0 +1,99 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// fakeHealth serves a settable instance list and honours blocking queries.
+type fakeHealth struct {
+	mu      sync.Mutex
+	index   uint64
+	entries []*consulapi.ServiceEntry
+	changed chan struct{}
+}
+
+func newFakeHealth() *fakeHealth {
+	return &fakeHealth{index: 1, changed: make(chan struct{})}
+}
+
+func (h *fakeHealth) set(addresses ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+	for _, address := range addresses {
+		host, portText, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portText)
+		h.entries = append(h.entries, &consulapi.ServiceEntry{
+			Node:    &consulapi.Node{Address: "10.0.0.1"},
+			Service: &consulapi.AgentService{Address: host, Port: port},
+		})
+	}
+	h.index++
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+func (h *fakeHealth) Service(service, tag string, passingOnly bool, q *consulapi.QueryOptions) ([]*consulapi.ServiceEntry, *consulapi.QueryMeta, error) {
+	for {
+		h.mu.Lock()
+		index, entries, changed := h.index, h.entries, h.changed
+		h.mu.Unlock()
+		if q.WaitIndex < index {
+			return entries, &consulapi.QueryMeta{LastIndex: index}, nil
+		}
+		select {
+		case <-changed:
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+	}
+}
+
+func TestServiceResolver(t *testing.T) {
+	health := newFakeHealth()
+	fallback := ""
+	resolver := NewServiceResolver(health, "chargers", func() (string, bool) {
+		return fallback, fallback != ""
+	}, log.NewNopLogger())
+	if err := resolver.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.Next(); !errors.Is(err, errNoInstances) {
+		t.Errorf("no instances and no fallback: %v", err)
+	}
+	fallback = "http://chargers.example:8080"
+	if got, _ := resolver.Next(); got != fallback {
+		t.Errorf("got %q, want the fallback", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go resolver.Run(ctx)
+	health.set("10.0.0.2:8080", "10.0.0.3:8080")
+	waitFor(t, "instances", func() bool { return len(resolver.Instances()) == 2 })
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		address, err := resolver.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[address]++
+	}
+	if seen["http://10.0.0.2:8080"] != 2 || seen["http://10.0.0.3:8080"] != 2 {
+		t.Errorf("not round-robin: %v", seen)
+	}
+
+	health.set()
+	waitFor(t, "instances gone", func() bool { return len(resolver.Instances()) == 0 })
+	if got, _ := resolver.Next(); got != fallback {
+		t.Errorf("got %q, want the fallback", got)
+	}
+}
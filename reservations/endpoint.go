@@ -2,6 +2,7 @@ package reservations
 
 import (
 	"context"
+	"reflect"
 
 	"github.com/go-kit/kit/endpoint"
 )
@@ -14,6 +15,17 @@ type Endpoints struct {
 	UpdateReservation     endpoint.Endpoint
 	DeleteReservation     endpoint.Endpoint
 	ReservationClosest    endpoint.Endpoint
+	SearchChargers        endpoint.Endpoint
+	ConfirmReservation    endpoint.Endpoint
+	CheckInReservation    endpoint.Endpoint
+	CompleteReservation   endpoint.Endpoint
+	CancelReservation     endpoint.Endpoint
+	NoShowReservation     endpoint.Endpoint
+	ExpireReservation     endpoint.Endpoint
+	GetAvailability       endpoint.Endpoint
+	CreateRecurring       endpoint.Endpoint
+	UpdateSeries          endpoint.Endpoint
+	CancelSeries          endpoint.Endpoint
 }
 
 func MakeEndpoints(s ReservationsService) Endpoints {
@@ -25,13 +37,35 @@ func MakeEndpoints(s ReservationsService) Endpoints {
 		UpdateReservation:     makeUpdateReservationEndpoint(s),
 		DeleteReservation:     makeDeleteReservationEndpoint(s),
 		ReservationClosest:    makeReservationClosestEndpoint(s),
+		SearchChargers:        makeSearchChargersEndpoint(s),
+		ConfirmReservation:    makeTransitionReservationEndpoint(s, StateConfirmed),
+		CheckInReservation:    makeTransitionReservationEndpoint(s, StateCheckedIn),
+		CompleteReservation:   makeTransitionReservationEndpoint(s, StateCompleted),
+		CancelReservation:     makeTransitionReservationEndpoint(s, StateCancelled),
+		NoShowReservation:     makeTransitionReservationEndpoint(s, StateNoShow),
+		ExpireReservation:     makeTransitionReservationEndpoint(s, StateExpired),
+		GetAvailability:       makeGetAvailabilityEndpoint(s),
+		CreateRecurring:       makeCreateRecurringEndpoint(s),
+		UpdateSeries:          makeUpdateSeriesEndpoint(s),
+		CancelSeries:          makeCancelSeriesEndpoint(s),
 	}
 }
 
+// Wrap returns a copy of e with mw applied to every endpoint.
+func (e Endpoints) Wrap(mw endpoint.Middleware) Endpoints {
+	v := reflect.ValueOf(&e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if next, ok := v.Field(i).Interface().(endpoint.Endpoint); ok && next != nil {
+			v.Field(i).Set(reflect.ValueOf(mw(next)))
+		}
+	}
+	return e
+}
+
 func makeCreateReservationEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(CreateReservationRequest)
-		status, err := s.CreateReservation(ctx, req.From, req.To, req.UserToken, req.ChargerID)
+		status, err := s.CreateReservation(ctx, req.From, req.To, req.ChargerID)
 		return CreateReservationResponse{Status: status}, err
 	}
 }
@@ -42,8 +76,12 @@ func makeGetReservationEndpoint(s ReservationsService) endpoint.Endpoint {
 		return GetReservationResponse{
 			ChargerID: reservation.ChargerID.Hex(),
 			UserID:    reservation.UserID.Hex(),
+			SeriesID:  seriesIDHex(reservation.SeriesID),
 			From:      reservation.From,
 			To:        reservation.To,
+			State:     reservation.State,
+			History:   reservation.History,
+			Version:   reservation.Version,
 			Created:   reservation.Created,
 			Modified:  reservation.Modified,
 		}, err
@@ -51,61 +89,121 @@ func makeGetReservationEndpoint(s ReservationsService) endpoint.Endpoint {
 }
 func makeGetReservationsEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		reservations, err := s.GetReservations(ctx)
+		req := request.(GetReservationsRequest)
+		page, err := s.GetReservations(ctx, req.States, req.Page)
 		return GetReservationsResponse{
-			Reservations: reservations,
+			Reservations: page.Reservations,
+			NextCursor:   page.NextCursor,
 		}, err
 	}
 }
 func makeDeleteReservationEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(DeleteReservationRequest)
-		status, err := s.DeleteReservation(ctx, req.Id)
+		status, err := s.DeleteReservation(ctx, req.Id, req.ExpectedVersions)
 		return DeleteReservationResponse{
 			Status: status,
 		}, err
 	}
 }
 
-func makeGetRatingsFilterEndpoint(s ReservationsService) endpoint.Endpoint {
-	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(GetReservationsFilterRequest)
-		reservations, err := s.GetReservationsFilter(ctx, req.ChargerID, req.UserID)
-		return GetReservationsFilterResponse{
-			Reservations: reservations,
-		}, err
-	}
-}
 func makeUpdateReservationEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UpdateReservationRequest)
-		status, err := s.UpdateReservation(ctx, req.Id, req.From, req.To)
+		status, err := s.UpdateReservation(ctx, req.Id, req.From, req.To, req.ExpectedVersions)
 		return CreateReservationResponse{Status: status}, err
 	}
 }
 func makeGetReservationsFilterEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(GetReservationsFilterRequest)
-		reservations, err := s.GetReservationsFilter(ctx, req.ChargerID, req.UserID)
+		page, err := s.GetReservationsFilter(ctx, ReservationFilter{
+			ChargerID:   req.ChargerID,
+			ChargerIDs:  req.ChargerIDs,
+			UserID:      req.UserID,
+			States:      req.States,
+			From:        req.From,
+			To:          req.To,
+			CreatedFrom: req.CreatedFrom,
+			CreatedTo:   req.CreatedTo,
+		}, req.Page)
 		return GetReservationsFilterResponse{
-			Reservations: reservations,
+			Reservations: page.Reservations,
+			NextCursor:   page.NextCursor,
 		}, err
 	}
 }
 func makeReservationClosestEndpoint(s ReservationsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(ReservationClosestRequest)
-		reservation, status, err := s.ReservationClosest(ctx, req.UserToken, req.From, req.To, req.Location)
+		// Validate has made sure the location is set.
+		reservation, status, err := s.ReservationClosest(ctx, req.From, req.To, *req.Location)
+		if err != nil || status != "Ok" {
+			return CreateReservationResponse{Status: status}, err
+		}
+		return ReservationClosestResponse{
+			Status:    status,
+			ID:        reservation.ID.Hex(),
+			ChargerID: reservation.ChargerID.Hex(),
+			UserID:    reservation.UserID.Hex(),
+			From:      reservation.From,
+			To:        reservation.To,
+			Created:   reservation.Created,
+			Modified:  reservation.Modified,
+		}, nil
+	}
+}
+func makeTransitionReservationEndpoint(s ReservationsService, to ReservationState) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TransitionReservationRequest)
+		reservation, status, err := s.TransitionReservation(ctx, req.Id, to)
+		return TransitionReservationResponse{
+			Status:  status,
+			State:   reservation.State,
+			History: reservation.History,
+		}, err
+	}
+}
+func makeGetAvailabilityEndpoint(s ReservationsService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetAvailabilityRequest)
+		availability, err := s.GetAvailability(ctx, req.ChargerID, req.From, req.To, req.Slot)
+		return GetAvailabilityResponse{Availability: availability}, err
+	}
+}
+func makeCreateRecurringEndpoint(s ReservationsService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateRecurringRequest)
+		series, occurrences, status, err := s.CreateRecurringReservation(ctx, req.ChargerID, req.From, req.To, req.RRule)
+		return CreateRecurringResponse{
+			Status:      status,
+			SeriesID:    series.ID.Hex(),
+			Occurrences: occurrences,
+		}, err
+	}
+}
+func makeUpdateSeriesEndpoint(s ReservationsService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateSeriesRequest)
+		occurrences, err := s.UpdateSeries(ctx, req.Id, req.From, req.To, req.Scope)
+		status := "Ok"
 		if err != nil {
-			return ReservationClosestResponse{
-				ChargerID: reservation.ChargerID.Hex(),
-				UserID:    reservation.UserID.Hex(),
-				From:      reservation.From,
-				To:        reservation.To,
-				Created:   reservation.Created,
-				Modified:  reservation.Modified,
-			}, err
+			status = "Error"
 		}
-		return CreateReservationResponse{Status: status}, err
+		return SeriesResponse{Status: status, Occurrences: occurrences}, err
+	}
+}
+func makeCancelSeriesEndpoint(s ReservationsService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CancelSeriesRequest)
+		occurrences, status, err := s.CancelSeries(ctx, req.Id, req.Scope)
+		return SeriesResponse{Status: status, Occurrences: occurrences}, err
+	}
+}
+func makeSearchChargersEndpoint(s ReservationsService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SearchChargersRequest)
+		candidates, err := s.SearchChargers(ctx, req.From, req.To, *req.Location, req.Limit)
+		return SearchChargersResponse{Candidates: candidates}, err
 	}
 }
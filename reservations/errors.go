@@ -0,0 +1,74 @@
+package reservations
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds. Every error the service returns on purpose belongs to one of
+// these, and errors.Is(err, kind) tells callers and the HTTP layer how to
+// treat it.
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrInvalidArgument     = errors.New("invalid argument")
+	ErrConflict            = errors.New("conflict")
+	ErrUnauthorized        = errors.New("missing or invalid bearer token")
+	ErrForbidden           = errors.New("not allowed to access this reservation")
+	ErrUpstreamUnavailable = errors.New("upstream service unavailable")
+)
+
+var (
+	ErrReservationNotFound = kindError(ErrNotFound, "reservation not found")
+	ErrReservationConflict = kindError(ErrConflict, "reservation overlaps an existing reservation for this charger")
+	ErrSeriesNotFound      = kindError(ErrNotFound, "reservation series not found")
+	ErrWindowReversed      = kindError(ErrInvalidArgument, "reservation must end after it starts")
+	ErrWindowInPast        = kindError(ErrInvalidArgument, "reservation must start in the future")
+	ErrWindowTooLong       = kindError(ErrInvalidArgument, "reservation window is too long")
+	ErrIllegalTransition   = kindError(ErrConflict, "reservation cannot move to the requested state")
+	ErrNotReschedulable    = kindError(ErrConflict, "only pending or confirmed reservations can be rescheduled")
+	ErrInvalidRange        = kindError(ErrInvalidArgument, "invalid availability range")
+	ErrNoOccurrences       = kindError(ErrInvalidArgument, "recurrence rule yields no occurrences")
+	ErrNoChargerAvailable  = kindError(ErrConflict, "no charger available within the search radius")
+	ErrVersionMismatch     = kindError(ErrConflict, "reservation was modified by another request")
+	ErrInvalidETag         = kindError(ErrInvalidArgument, "malformed If-Match header")
+	ErrInvalidCursor       = kindError(ErrInvalidArgument, "malformed or mismatched page cursor")
+
+	ErrIdempotencyKeyReused  = kindError(ErrInvalidArgument, "idempotency key was already used with a different request")
+	ErrIdempotencyInProgress = kindError(ErrConflict, "a request with this idempotency key is still in progress")
+)
+
+// domainError attaches an error kind to err without changing its message.
+type domainError struct {
+	kind error
+	err  error
+}
+
+func (e *domainError) Error() string        { return e.err.Error() }
+func (e *domainError) Unwrap() error        { return e.err }
+func (e *domainError) Is(target error) bool { return target == e.kind }
+
+func kindError(kind error, msg string) error {
+	return &domainError{kind: kind, err: errors.New(msg)}
+}
+
+// invalidArgument marks err, typically a parse failure, as the caller's
+// fault.
+func invalidArgument(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &domainError{kind: ErrInvalidArgument, err: err}
+}
+
+// invalidID reports a malformed ObjectID in field.
+func invalidID(field string, value string) error {
+	return invalidArgument(fmt.Errorf("%s %q is not a valid id", field, value))
+}
+
+// unavailable marks err as a failure of a service we depend on.
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &domainError{kind: ErrUpstreamUnavailable, err: err}
+}
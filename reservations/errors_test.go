@@ -0,0 +1,69 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestErrorStatus(t *testing.T) {
+	_, parseErr := time.Parse(time.RFC3339, "tomorrow")
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{ErrReservationNotFound, http.StatusNotFound},
+		{ErrWindowReversed, http.StatusBadRequest},
+		{invalidArgument(parseErr), http.StatusBadRequest},
+		{invalidID("id", "xyz"), http.StatusBadRequest},
+		{ErrReservationConflict, http.StatusConflict},
+		{ErrIllegalTransition, http.StatusConflict},
+		{ErrVersionMismatch, http.StatusPreconditionFailed},
+		{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity},
+		{fmt.Errorf("%w: token expired", ErrUnauthorized), http.StatusUnauthorized},
+		{ErrForbidden, http.StatusForbidden},
+		{unavailable(errors.New("connection refused")), http.StatusServiceUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if status, _ := errorStatus(c.err); status != c.status {
+			t.Errorf("%v: got %d, want %d", c.err, status, c.status)
+		}
+	}
+	if !errors.Is(ErrWindowInPast, ErrInvalidArgument) || errors.Is(ErrWindowInPast, ErrWindowReversed) {
+		t.Error("domain errors must match their kind and only themselves")
+	}
+}
+
+func TestEncodeErrorProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	encodeError(context.Background(), ErrReservationNotFound, w)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("got content type %q", ct)
+	}
+	problem := Problem{}
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "reservation not found", Code: "not_found"}
+	if !reflect.DeepEqual(problem, want) {
+		t.Errorf("got %+v, want %+v", problem, want)
+	}
+
+	w = httptest.NewRecorder()
+	encodeError(context.Background(), errors.New("server selection timeout"), w)
+	problem = Problem{}
+	json.NewDecoder(w.Body).Decode(&problem)
+	if problem.Status != 500 || problem.Detail != "" {
+		t.Errorf("internal errors must not leak details: %+v", problem)
+	}
+}
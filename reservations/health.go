@@ -0,0 +1,76 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthTimeout bounds a single run of the health checks.
+const healthTimeout = 5 * time.Second
+
+// HealthCheck reports whether a dependency of the service is usable.
+type HealthCheck func(ctx context.Context) error
+
+// Health runs named checks and serves their results on the health route.
+type Health struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// HealthReport is the body of a health response.
+type HealthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// NewHealth returns a Health with no checks, which always passes.
+func NewHealth() *Health {
+	return &Health{checks: map[string]HealthCheck{}}
+}
+
+// Add registers check under name, replacing any check of that name.
+func (h *Health) Add(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Check runs every check and reports "pass" only if all of them passed.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for name, check := range h.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	report := HealthReport{Status: "pass", Checks: map[string]string{}}
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			report.Status = "fail"
+			report.Checks[name] = err.Error()
+			continue
+		}
+		report.Checks[name] = "ok"
+	}
+	return report
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+	w.Header().Set("Content-Type", "application/health+json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "pass" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
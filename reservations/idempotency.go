@@ -0,0 +1,214 @@
+package reservations
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultIdempotencyRetention is how long a stored response can be replayed.
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header.
+type IdempotencyRecord struct {
+	ID          string    `bson:"_id"`
+	UserID      string    `bson:"userid"`
+	Key         string    `bson:"key"`
+	Fingerprint string    `bson:"fingerprint"`
+	Completed   bool      `bson:"completed"`
+	Response    []byte    `bson:"response,omitempty"`
+	Expires     time.Time `bson:"expires"`
+}
+
+type IdempotencyStore interface {
+	// Reserve claims key for userID. If the key is already claimed, the
+	// existing record is returned and created is false.
+	Reserve(ctx context.Context, userID string, key string, fingerprint string, expires time.Time) (record IdempotencyRecord, created bool, err error)
+	// Complete stores the response for a claimed key.
+	Complete(ctx context.Context, userID string, key string, response []byte) error
+	// Release drops a claim whose request failed, so it can be retried.
+	Release(ctx context.Context, userID string, key string) error
+}
+
+type idempotencyStore struct {
+	collection *mongo.Collection
+	logger     log.Logger
+}
+
+// NewIdempotencyStore keeps idempotency records in the IdempotencyKeys
+// collection. Expired records are removed by the TTL index that MigrateMongo
+// creates.
+func NewIdempotencyStore(db *mongo.Database, logger log.Logger) IdempotencyStore {
+	return &idempotencyStore{
+		collection: db.Collection("IdempotencyKeys"),
+		logger:     log.With(logger, "component", "idempotency"),
+	}
+}
+
+func idempotencyID(userID string, key string) string {
+	return userID + "/" + key
+}
+
+func (st *idempotencyStore) Reserve(ctx context.Context, userID string, key string, fingerprint string, expires time.Time) (IdempotencyRecord, bool, error) {
+	record := IdempotencyRecord{
+		ID:          idempotencyID(userID, key),
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Expires:     expires,
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := st.collection.InsertOne(ctx, record)
+		if err == nil {
+			return record, true, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return record, false, err
+		}
+		existing := IdempotencyRecord{}
+		err = st.collection.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return record, false, err
+		}
+		if existing.Expires.After(time.Now()) {
+			return existing, false, nil
+		}
+		// The TTL monitor has not removed the expired record yet.
+		_, err = st.collection.DeleteOne(ctx, bson.M{"_id": record.ID, "expires": existing.Expires})
+		if err != nil {
+			return record, false, err
+		}
+	}
+	return record, false, ErrIdempotencyInProgress
+}
+
+func (st *idempotencyStore) Complete(ctx context.Context, userID string, key string, response []byte) error {
+	update := bson.M{"$set": bson.M{"completed": true, "response": response}}
+	_, err := st.collection.UpdateOne(ctx, bson.M{"_id": idempotencyID(userID, key)}, update)
+	return err
+}
+
+func (st *idempotencyStore) Release(ctx context.Context, userID string, key string) error {
+	_, err := st.collection.DeleteOne(ctx, bson.M{"_id": idempotencyID(userID, key), "completed": false})
+	return err
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+// NewMemoryIdempotencyStore keeps idempotency records in process memory,
+// for use with the in-memory database.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}}
+}
+
+func (st *memoryIdempotencyStore) Reserve(ctx context.Context, userID string, key string, fingerprint string, expires time.Time) (IdempotencyRecord, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	id := idempotencyID(userID, key)
+	if existing, ok := st.records[id]; ok && existing.Expires.After(time.Now()) {
+		return existing, false, nil
+	}
+	record := IdempotencyRecord{
+		ID:          id,
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Expires:     expires,
+	}
+	st.records[id] = record
+	return record, true, nil
+}
+
+func (st *memoryIdempotencyStore) Complete(ctx context.Context, userID string, key string, response []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	id := idempotencyID(userID, key)
+	if record, ok := st.records[id]; ok {
+		record.Completed = true
+		record.Response = response
+		st.records[id] = record
+	}
+	return nil
+}
+
+func (st *memoryIdempotencyStore) Release(ctx context.Context, userID string, key string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	id := idempotencyID(userID, key)
+	if record, ok := st.records[id]; ok && !record.Completed {
+		delete(st.records, id)
+	}
+	return nil
+}
+
+// MakeIdempotencyMiddleware replays the stored response of requests repeated
+// with the same Idempotency-Key by the same user. Repeating a key with a
+// different request body fails with ErrIdempotencyKeyReused. Requests
+// without a key, or without an authenticated caller, pass through untouched.
+func MakeIdempotencyMiddleware(store IdempotencyStore, retention time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key, _ := ctx.Value(contextKeyIdempotencyKey).(string)
+			principal, ok := PrincipalFromContext(ctx)
+			if key == "" || !ok {
+				return next(ctx, request)
+			}
+			userID := principal.UserID
+			body, err := json.Marshal(request)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			record, created, err := store.Reserve(ctx, userID, key, fingerprint, time.Now().Add(retention))
+			if err != nil {
+				return nil, err
+			}
+			if !created {
+				if record.Fingerprint != fingerprint {
+					return nil, ErrIdempotencyKeyReused
+				}
+				if !record.Completed {
+					return nil, ErrIdempotencyInProgress
+				}
+				return json.RawMessage(record.Response), nil
+			}
+
+			response, err := next(ctx, request)
+			if err != nil {
+				store.Release(ctx, userID, key)
+				return response, err
+			}
+			encoded, err := json.Marshal(response)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.Complete(ctx, userID, key, encoded); err != nil {
+				return nil, err
+			}
+			return response, nil
+		}
+	}
+}
+
+// populateIdempotencyKey moves the Idempotency-Key header into the context.
+func populateIdempotencyKey(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, contextKeyIdempotencyKey, r.Header.Get("Idempotency-Key"))
+}
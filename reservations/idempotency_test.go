@@ -0,0 +1,129 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ht "github.com/go-kit/kit/transport/http"
+)
+
+// idempotentServer serves next behind the idempotency middleware, using the
+// X-User header as the authenticated caller.
+func idempotentServer(next func(ctx context.Context, request interface{}) (interface{}, error)) http.Handler {
+	withUser := func(ctx context.Context, r *http.Request) context.Context {
+		return NewContextWithPrincipal(ctx, Principal{UserID: r.Header.Get("X-User")})
+	}
+	return ht.NewServer(
+		MakeIdempotencyMiddleware(NewMemoryIdempotencyStore(), time.Hour)(next),
+		decodeCreateReservationRequest,
+		encodeResponse,
+		ht.ServerBefore(populateIdempotencyKey, withUser),
+		ht.ServerErrorEncoder(encodeError),
+	)
+}
+
+func idempotentRequest(handler http.Handler, user string, key string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/reservations", strings.NewReader(body))
+	r.Header.Set("X-User", user)
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+const (
+	bookingA = `{"chargerID":"a","from":"2030-01-01T10:00:00Z","to":"2030-01-01T11:00:00Z"}`
+	bookingB = `{"chargerID":"b","from":"2030-01-01T10:00:00Z","to":"2030-01-01T11:00:00Z"}`
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	fail := false
+	handler := idempotentServer(func(ctx context.Context, request interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fail {
+			return nil, ErrReservationConflict
+		}
+		return CreateReservationResponse{Status: strings.Repeat("Ok", calls)}, nil
+	})
+	called := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	first := idempotentRequest(handler, "alice", "k1", bookingA)
+	replay := idempotentRequest(handler, "alice", "k1", bookingA)
+	if first.Code != http.StatusOK || replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay got %d %q, want %d %q", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if called() != 1 {
+		t.Errorf("replay reached the endpoint: %d calls", called())
+	}
+
+	if w := idempotentRequest(handler, "alice", "k1", bookingB); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body: got %d %s", w.Code, w.Body)
+	}
+
+	if w := idempotentRequest(handler, "bob", "k1", bookingA); w.Code != http.StatusOK || called() != 2 {
+		t.Errorf("key of another user was shared: got %d, %d calls", w.Code, called())
+	}
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	if w := idempotentRequest(handler, "alice", "k2", bookingA); w.Code != http.StatusConflict {
+		t.Fatalf("failing request: got %d %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if w := idempotentRequest(handler, "alice", "k2", bookingA); w.Code != http.StatusOK || called() != 4 {
+		t.Errorf("retry after a failure: got %d %s, %d calls", w.Code, w.Body, called())
+	}
+}
+
+func TestIdempotencyMiddlewareInFlight(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := idempotentServer(func(ctx context.Context, request interface{}) (interface{}, error) {
+		close(entered)
+		<-release
+		return CreateReservationResponse{Status: "Ok"}, nil
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentRequest(handler, "alice", "k1", bookingA) }()
+	<-entered
+	if w := idempotentRequest(handler, "alice", "k1", bookingA); w.Code != http.StatusConflict {
+		t.Errorf("request in flight: got %d %s", w.Code, w.Body)
+	} else if !strings.Contains(w.Body.String(), ErrIdempotencyInProgress.Error()) {
+		t.Errorf("request in flight: got problem %s", w.Body)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("first request: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestIdempotencyMiddlewareWithoutKey(t *testing.T) {
+	calls := 0
+	handler := idempotentServer(func(ctx context.Context, request interface{}) (interface{}, error) {
+		calls++
+		return nil, errors.New("boom")
+	})
+	idempotentRequest(handler, "alice", "", bookingA)
+	idempotentRequest(handler, "alice", "", bookingA)
+	if calls != 2 {
+		t.Errorf("requests without a key were deduplicated: %d calls", calls)
+	}
+}
@@ -0,0 +1,254 @@
+package reservations
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// DefaultIndexRefresh is how often the charger index reloads the charger
+// list by default.
+const DefaultIndexRefresh = time.Minute
+
+// earthRadius is the sphere radius, in kilometres, implied by calcDistance,
+// so that distances from the index agree with it.
+const earthRadius = 60 * 1.1515 * 1.60934 * 180 / math.Pi
+
+// ChargerIndex keeps charger locations in a k-d tree over points on the unit
+// sphere, so nearest and radius lookups do not have to scan every charger.
+// Straight-line (chord) distance between unit vectors grows monotonically
+// with great-circle distance, which lets the tree prune by chord length.
+type ChargerIndex struct {
+	mu      sync.RWMutex
+	root    *kdNode
+	size    int
+	loaded  bool
+	fetch   func(ctx context.Context) ([]Charger, error)
+	logger  log.Logger
+	refresh sync.Mutex
+}
+
+type kdNode struct {
+	point   [3]float64
+	charger Charger
+	axis    int
+	left    *kdNode
+	right   *kdNode
+}
+
+// NewChargerIndex creates an index that loads chargers through client.
+func NewChargerIndex(client ChargersClient, logger log.Logger) *ChargerIndex {
+	logger = log.With(logger, "component", "chargerIndex")
+	return &ChargerIndex{
+		fetch:  client.Chargers,
+		logger: logger,
+	}
+}
+
+// Run refreshes the index every interval until ctx is done.
+func (idx *ChargerIndex) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := idx.Refresh(ctx); err != nil {
+			level.Error(idx.logger).Log("msg", "refreshing charger index", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh reloads the charger list and rebuilds the tree. Lookups keep
+// using the previous tree until the new one is ready.
+func (idx *ChargerIndex) Refresh(ctx context.Context) error {
+	idx.refresh.Lock()
+	defer idx.refresh.Unlock()
+	chargers, err := idx.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	idx.Replace(chargers)
+	return nil
+}
+
+// Replace rebuilds the index from chargers.
+func (idx *ChargerIndex) Replace(chargers []Charger) {
+	nodes := make([]kdNode, len(chargers))
+	for i, charger := range chargers {
+		nodes[i] = kdNode{point: toUnitVector(charger.Location), charger: charger}
+	}
+	ptrs := make([]*kdNode, len(nodes))
+	for i := range nodes {
+		ptrs[i] = &nodes[i]
+	}
+	root := buildKDTree(ptrs, 0)
+	idx.mu.Lock()
+	idx.root = root
+	idx.size = len(chargers)
+	idx.loaded = true
+	idx.mu.Unlock()
+}
+
+// Len returns the number of indexed chargers.
+func (idx *ChargerIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.size
+}
+
+// ensureLoaded performs the first load synchronously, so requests arriving
+// before the background refresh has run still see chargers.
+func (idx *ChargerIndex) ensureLoaded(ctx context.Context) error {
+	idx.mu.RLock()
+	loaded := idx.loaded
+	idx.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return idx.Refresh(ctx)
+}
+
+// WithinRadius returns the chargers within radius kilometres of location,
+// nearest first.
+func (idx *ChargerIndex) WithinRadius(ctx context.Context, location Location, radius float64) ([]chargerDistance, error) {
+	if err := idx.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	target := toUnitVector(location)
+	maxChord := chordLength(radius)
+	found := []chargerDistance{}
+	idx.mu.RLock()
+	var walk func(node *kdNode)
+	walk = func(node *kdNode) {
+		if node == nil {
+			return
+		}
+		if squaredDistance(target, node.point) <= maxChord*maxChord {
+			dst, _ := calcDistance(location, node.charger.Location)
+			if dst <= radius {
+				found = append(found, chargerDistance{Charger: node.charger, Distance: dst})
+			}
+		}
+		diff := target[node.axis] - node.point[node.axis]
+		near, far := node.left, node.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		walk(near)
+		if diff*diff <= maxChord*maxChord {
+			walk(far)
+		}
+	}
+	walk(idx.root)
+	idx.mu.RUnlock()
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Distance < found[j].Distance })
+	return found, nil
+}
+
+// Nearest returns up to k chargers closest to location, nearest first.
+func (idx *ChargerIndex) Nearest(ctx context.Context, location Location, k int) ([]chargerDistance, error) {
+	if err := idx.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	target := toUnitVector(location)
+	best := &kdHeap{}
+	idx.mu.RLock()
+	var walk func(node *kdNode)
+	walk = func(node *kdNode) {
+		if node == nil || k <= 0 {
+			return
+		}
+		d := squaredDistance(target, node.point)
+		if best.Len() < k {
+			heap.Push(best, kdHeapItem{node: node, dist: d})
+		} else if d < (*best)[0].dist {
+			(*best)[0] = kdHeapItem{node: node, dist: d}
+			heap.Fix(best, 0)
+		}
+		diff := target[node.axis] - node.point[node.axis]
+		near, far := node.left, node.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		walk(near)
+		if best.Len() < k || diff*diff < (*best)[0].dist {
+			walk(far)
+		}
+	}
+	walk(idx.root)
+	idx.mu.RUnlock()
+	found := make([]chargerDistance, best.Len())
+	for i := len(found) - 1; i >= 0; i-- {
+		item := heap.Pop(best).(kdHeapItem)
+		dst, _ := calcDistance(location, item.node.charger.Location)
+		found[i] = chargerDistance{Charger: item.node.charger, Distance: dst}
+	}
+	return found, nil
+}
+
+func buildKDTree(nodes []*kdNode, depth int) *kdNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].point[axis] < nodes[j].point[axis] })
+	mid := len(nodes) / 2
+	node := nodes[mid]
+	node.axis = axis
+	node.left = buildKDTree(nodes[:mid], depth+1)
+	node.right = buildKDTree(nodes[mid+1:], depth+1)
+	return node
+}
+
+func toUnitVector(location Location) [3]float64 {
+	lat := location.Latitude * math.Pi / 180
+	lon := location.Longitude * math.Pi / 180
+	return [3]float64{
+		math.Cos(lat) * math.Cos(lon),
+		math.Cos(lat) * math.Sin(lon),
+		math.Sin(lat),
+	}
+}
+
+// chordLength converts a great-circle distance in kilometres to the chord
+// length between the corresponding unit vectors.
+func chordLength(km float64) float64 {
+	angle := km / earthRadius
+	if angle >= math.Pi {
+		return 2
+	}
+	return 2 * math.Sin(angle/2)
+}
+
+func squaredDistance(a [3]float64, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+type kdHeapItem struct {
+	node *kdNode
+	dist float64
+}
+
+// kdHeap is a max-heap on distance holding the current k best matches.
+type kdHeap []kdHeapItem
+
+func (h kdHeap) Len() int            { return len(h) }
+func (h kdHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h kdHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *kdHeap) Push(x interface{}) { *h = append(*h, x.(kdHeapItem)) }
+func (h *kdHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,143 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// chargersByDistance is the linear scan the index must agree with: the
+// chargers within maxRadius kilometres of location, nearest first.
+func chargersByDistance(location Location, chargers []Charger, maxRadius float64) []chargerDistance {
+	candidates := []chargerDistance{}
+	for _, charger := range chargers {
+		dst, _ := calcDistance(location, charger.Location)
+		if dst > maxRadius {
+			continue
+		}
+		candidates = append(candidates, chargerDistance{Charger: charger, Distance: dst})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Distance < candidates[j].Distance })
+	return candidates
+}
+
+func randomChargers(n int, seed int64) []Charger {
+	rnd := rand.New(rand.NewSource(seed))
+	chargers := make([]Charger, n)
+	for i := range chargers {
+		chargers[i] = Charger{
+			ID: primitive.NewObjectID(),
+			// Roughly Europe, so radius queries find neighbours.
+			Location: Location{
+				Latitude:  35 + rnd.Float64()*30,
+				Longitude: -10 + rnd.Float64()*40,
+			},
+		}
+	}
+	return chargers
+}
+
+func newTestIndex(chargers []Charger) *ChargerIndex {
+	idx := &ChargerIndex{}
+	idx.Replace(chargers)
+	return idx
+}
+
+func TestChargerIndexMatchesLinearScan(t *testing.T) {
+	chargers := randomChargers(5000, 1)
+	idx := newTestIndex(chargers)
+	for _, query := range randomChargers(50, 2) {
+		want := chargersByDistance(query.Location, chargers, 75)
+		got, err := idx.WithinRadius(context.Background(), query.Location, 75)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("radius: want %d chargers, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i].Charger.ID != want[i].Charger.ID {
+				t.Fatalf("radius: result %d differs", i)
+			}
+		}
+
+		all := chargersByDistance(query.Location, chargers, 1e9)
+		nearest, err := idx.Nearest(context.Background(), query.Location, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range nearest {
+			if nearest[i].Charger.ID != all[i].Charger.ID {
+				t.Fatalf("nearest: result %d is %v, want %v", i, nearest[i].Distance, all[i].Distance)
+			}
+		}
+	}
+}
+
+func TestBookClosestWidensSearch(t *testing.T) {
+	chargers := randomChargers(5000, 1)
+	idx := newTestIndex(chargers)
+	location := randomChargers(1, 2)[0].Location
+	inRange := chargersByDistance(location, chargers, 300)
+	if len(inRange) <= 3*closestBatch {
+		t.Fatalf("only %d chargers in range", len(inRange))
+	}
+
+	for _, free := range []int{0, 3 * closestBatch, -1} {
+		tried := []primitive.ObjectID{}
+		reservation, err := bookClosest(context.Background(), idx, location, 300, Reservation{}, func(r Reservation) (primitive.ObjectID, error) {
+			tried = append(tried, r.ChargerID)
+			if free < 0 || r.ChargerID != inRange[free].Charger.ID {
+				return primitive.NilObjectID, ErrReservationConflict
+			}
+			return primitive.NewObjectID(), nil
+		})
+		if free < 0 {
+			if !errors.Is(err, ErrNoChargerAvailable) || len(tried) != len(inRange) {
+				t.Errorf("all taken: %v after trying %d of %d chargers", err, len(tried), len(inRange))
+			}
+			continue
+		}
+		if err != nil || reservation.ChargerID != inRange[free].Charger.ID || len(tried) != free+1 {
+			t.Errorf("charger %d free: booked %s after %d tries, %v", free, reservation.ChargerID.Hex(), len(tried), err)
+		}
+		for i, id := range tried {
+			if id != inRange[i].Charger.ID {
+				t.Fatalf("charger %d free: try %d was not the next nearest", free, i)
+			}
+		}
+	}
+}
+
+const benchChargers = 50000
+
+func BenchmarkLinearWithinRadius(b *testing.B) {
+	chargers := randomChargers(benchChargers, 1)
+	queries := randomChargers(256, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chargersByDistance(queries[i%len(queries)].Location, chargers, DefaultMaxSearchRadius)
+	}
+}
+
+func BenchmarkIndexWithinRadius(b *testing.B) {
+	idx := newTestIndex(randomChargers(benchChargers, 1))
+	queries := randomChargers(256, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.WithinRadius(context.Background(), queries[i%len(queries)].Location, DefaultMaxSearchRadius)
+	}
+}
+
+func BenchmarkIndexNearest(b *testing.B) {
+	idx := newTestIndex(randomChargers(benchChargers, 1))
+	queries := randomChargers(256, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Nearest(context.Background(), queries[i%len(queries)].Location, 10)
+	}
+}
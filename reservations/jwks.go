@@ -0,0 +1,294 @@
+package reservations
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultKeyRefresh is how long fetched verification keys are cached.
+const DefaultKeyRefresh = 5 * time.Minute
+
+// minKeyRefetch rate limits refetches of a JWKS document triggered by
+// tokens with an unknown key ID.
+const minKeyRefetch = 30 * time.Second
+
+var errUnknownKey = errors.New("no verification key for token")
+
+// KeySource provides the keys used to verify token signatures.
+type KeySource interface {
+	// Key returns the verification key for a token signed with alg and
+	// carrying the key ID kid, which may be empty.
+	Key(kid string, alg string) (interface{}, error)
+}
+
+// KeySources tries each source in turn and returns the first key found.
+type KeySources []KeySource
+
+func (ks KeySources) Key(kid string, alg string) (interface{}, error) {
+	for _, source := range ks {
+		if key, err := source.Key(kid, alg); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errUnknownKey
+}
+
+// consulSecret serves the HMAC secret stored under a consul key. The value
+// is kept current by the ConsulConfig, so validating a token does not hit
+// consul.
+type consulSecret struct {
+	config *ConsulConfig
+	key    string
+}
+
+// NewConsulSecret returns a KeySource for HS256/384/512 tokens signed with
+// the secret stored under key in consul.
+func NewConsulSecret(config *ConsulConfig, key string) KeySource {
+	return &consulSecret{config: config, key: key}
+}
+
+func (cs *consulSecret) Key(kid string, alg string) (interface{}, error) {
+	if !isHMAC(alg) {
+		return nil, errUnknownKey
+	}
+	secret, ok := cs.config.Lookup(cs.key)
+	if !ok {
+		return nil, errUnknownKey
+	}
+	return []byte(secret), nil
+}
+
+// JWKS serves RSA and EC public keys from a JSON Web Key Set (RFC 7517)
+// read from a file or fetched from a URL. Keys are cached and reloaded
+// every refresh interval, and sooner when a token names an unknown key ID,
+// so that keys can be rotated without restarting the service.
+type JWKS struct {
+	load    func() ([]byte, error)
+	refresh time.Duration
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+	// loading is closed when the reload in flight, if any, is done; err is
+	// the result of the last one.
+	loading chan struct{}
+	err     error
+}
+
+// NewJWKSFile reads the key set from a local file.
+func NewJWKSFile(path string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		load:    func() ([]byte, error) { return os.ReadFile(path) },
+		refresh: refresh,
+	}
+}
+
+// NewJWKSURL fetches the key set over HTTP.
+func NewJWKSURL(url string, refresh time.Duration) *JWKS {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &JWKS{
+		load: func() ([]byte, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("jwks: %s returned %s", url, resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+		refresh: refresh,
+	}
+}
+
+func (j *JWKS) Key(kid string, alg string) (interface{}, error) {
+	keys, err := j.keySet(kid)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[kid]
+	if !ok && kid == "" && len(keys) == 1 {
+		// Tokens without a kid are accepted when the set has a single key.
+		for _, only := range keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if !keyMatchesAlg(key, alg) {
+		return nil, fmt.Errorf("jwks: key %q cannot verify %s", kid, alg)
+	}
+	return key, nil
+}
+
+// keySet returns the cached keys, reloaded first when they are stale or do
+// not have kid. The key set is fetched without holding j.mu and only once
+// for concurrent callers; those that already know kid keep using the cached
+// keys meanwhile.
+func (j *JWKS) keySet(kid string) (map[string]interface{}, error) {
+	j.mu.Lock()
+	_, known := j.keys[kid]
+	stale := time.Since(j.fetched) > j.refresh
+	if j.keys != nil && !stale && (known || time.Since(j.fetched) <= minKeyRefetch) {
+		defer j.mu.Unlock()
+		return j.keys, nil
+	}
+	if loading := j.loading; loading != nil {
+		cached := j.keys
+		j.mu.Unlock()
+		if known {
+			return cached, nil
+		}
+		<-loading
+		j.mu.Lock()
+	} else {
+		loading = make(chan struct{})
+		j.loading = loading
+		j.fetched = time.Now()
+		j.mu.Unlock()
+		keys, err := j.fetch()
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+		}
+		j.err = err
+		j.loading = nil
+		close(loading)
+	}
+	defer j.mu.Unlock()
+	if j.keys == nil {
+		return nil, j.err
+	}
+	return j.keys, nil
+}
+
+func (j *JWKS) fetch() (map[string]interface{}, error) {
+	data, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the signature keys of a key set, indexed by key ID.
+// Keys of unsupported types, or meant for encryption, are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %v", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaKey()
+		case "EC":
+			key, err = jwk.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (jwk jsonWebKey) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func isHMAC(alg string) bool {
+	return alg == "HS256" || alg == "HS384" || alg == "HS512"
+}
+
+// keyMatchesAlg guards against algorithm confusion, such as an RSA public
+// key being used as an HMAC secret.
+func keyMatchesAlg(key interface{}, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" || alg == "RS384" || alg == "RS512"
+	case *ecdsa.PublicKey:
+		return alg == "ES256" || alg == "ES384" || alg == "ES512"
+	case []byte:
+		return isHMAC(alg)
+	}
+	return false
+}
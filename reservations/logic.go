@@ -2,80 +2,113 @@ package reservations
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"sort"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	consulapi "github.com/hashicorp/consul/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// MaxReservationDuration caps the length of a single reservation window.
+const MaxReservationDuration = 24 * time.Hour
+
 type service struct {
-	db     ReservationDB
-	logger log.Logger
-	consul consulapi.Client
+	db       ReservationDB
+	logger   log.Logger
+	scorer   Scorer
+	policy   Policy
+	chargers *ChargerChecker
 }
 
-func NewService(db ReservationDB, logger log.Logger, consul consulapi.Client) ReservationsService {
+// NewService creates the reservations service. chargers checks the charger
+// of new and moved reservations; nil skips the check.
+func NewService(db ReservationDB, logger log.Logger, scorer Scorer, policy Policy, chargers *ChargerChecker) ReservationsService {
 	return &service{
-		db:     db,
-		logger: logger,
-		consul: consul,
+		db:       db,
+		logger:   logger,
+		scorer:   scorer,
+		policy:   policy,
+		chargers: chargers,
 	}
 }
 
-func (s service) CreateReservation(ctx context.Context, from string, to string, userToken string, chargerID string) (string, error) {
+func (s *service) CreateReservation(ctx context.Context, from time.Time, to time.Time, chargerID string) (string, error) {
 	logger := log.With(s.logger, "method: ", "CreateReservation")
-	secret, _ := getConsulValue(s.consul, s.logger, "jwtSecret")
-	token := strings.Split(userToken, " ")
-	if len(token) != 2 {
-		return "Authorization failed", nil
+	principal, err := principalFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return "", err
 	}
-	userID, err := FromJWT(token[1], secret)
+	chargerObjectID, err := primitive.ObjectIDFromHex(chargerID)
 	if err != nil {
-		return "Authorization failed", nil
+		return "", invalidID("chargerID", chargerID)
 	}
-	if err := s.db.CreateReservation(ctx, from, to, userID, chargerID); err != nil {
+	if err := s.chargers.Check(ctx, chargerObjectID); err != nil {
+		level.Error(logger).Log("err", err)
+		return "", err
+	}
+	if err := s.db.CreateReservation(ctx, from, to, principal.UserID, chargerID); err != nil {
 		level.Error(logger).Log("err", err)
 		return "", err
 	}
 	logger.Log("create Reservation", nil)
 	return "Ok", nil
 }
-func (s service) GetReservation(ctx context.Context, id string) (Reservation, error) {
+func (s *service) GetReservation(ctx context.Context, id string) (Reservation, error) {
 	logger := log.With(s.logger, "method", "GetReservation")
 	reservation, err := s.db.GetReservation(ctx, id)
 	if err != nil {
 		level.Error(logger).Log("err", err)
 		return reservation, err
 	}
+	if err := s.authorize(ctx, ActionRead, reservation); err != nil {
+		return Reservation{}, err
+	}
 	logger.Log("Get Reservation", id)
 	return reservation, nil
 }
-func (s service) GetReservations(ctx context.Context) ([]Reservation, error) {
-	logger := log.With(s.logger, "method", "GetReservation")
-	reservations, err := s.db.GetReservations(ctx)
-	if err != nil {
-		level.Error(logger).Log("err", err)
-		return reservations, err
-	}
-	logger.Log("Get Reservations")
-	return reservations, nil
+func (s *service) GetReservations(ctx context.Context, states []ReservationState, page PageRequest) (ReservationPage, error) {
+	return s.listReservations(ctx, "GetReservations", ReservationFilter{States: states}, page)
 }
 
-func (s service) GetReservationsFilter(ctx context.Context, chargerID string, userID string) ([]Reservation, error) {
-	logger := log.With(s.logger, "method", "GetReservationsFilter")
-	reservations, err := s.db.GetReservationsFilter(ctx, chargerID, userID)
+func (s *service) GetReservationsFilter(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error) {
+	return s.listReservations(ctx, "GetReservationsFilter", filter, page)
+}
+
+// listReservations returns one page of the reservations matching filter that
+// the caller may see.
+func (s *service) listReservations(ctx context.Context, method string, filter ReservationFilter, page PageRequest) (ReservationPage, error) {
+	logger := log.With(s.logger, "method", method)
+	filter, err := s.scopeFilter(ctx, filter)
+	if err != nil {
+		return ReservationPage{}, err
+	}
+	result, err := s.db.GetReservationsPage(ctx, filter, page)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return reservations, err
+		return result, err
 	}
-	logger.Log("Get ReservationsFilter")
-	return reservations, nil
+	logger.Log("list Reservations", len(result.Reservations))
+	return result, nil
 }
-func (s service) DeleteReservation(ctx context.Context, id string) (string, error) {
+func (s *service) DeleteReservation(ctx context.Context, id string, expectedVersions []int64) (string, error) {
 	logger := log.With(s.logger, "method", "DeleteReservation")
-	err := s.db.DeleteReservation(ctx, id)
+	reservation, err := s.db.GetReservation(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if err := s.authorize(ctx, ActionDelete, reservation); err != nil {
+		return "", err
+	}
+	version, err := expectedVersion(reservation.Version, expectedVersions)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.DeleteReservation(ctx, id, version)
 	if err != nil {
 		level.Error(logger).Log("err", err)
 		return "", err
@@ -83,29 +116,47 @@ func (s service) DeleteReservation(ctx context.Context, id string) (string, erro
 	logger.Log("Delete Rating", id)
 	return "Ok", nil
 }
-func (s service) UpdateReservation(ctx context.Context, id string, from string, to string) (string, error) {
+func (s *service) UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersions []int64) (string, error) {
 	logger := log.With(s.logger, "method: ", "UpdateRating")
-
-	if err := s.db.UpdateReservation(ctx, id, from, to); err != nil {
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return "", err
+	}
+	reservation, err := s.db.GetReservation(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if err := s.authorize(ctx, ActionUpdate, reservation); err != nil {
+		return "", err
+	}
+	if !CanReschedule(reservation.State) {
+		return "", ErrNotReschedulable
+	}
+	version, err := expectedVersion(reservation.Version, expectedVersions)
+	if err != nil {
+		return "", err
+	}
+	if err := s.chargers.Check(ctx, reservation.ChargerID); err != nil {
+		level.Error(logger).Log("err", err)
+		return "", err
+	}
+	if err := s.db.UpdateReservation(ctx, id, from, to, version); err != nil {
 		level.Error(logger).Log("err", err)
 		return "", err
 	}
 	logger.Log("update Rating", id)
 	return "Ok", nil
 }
-func (s service) ReservationClosest(ctx context.Context, userToken string, from string, to string, location Location) (Reservation, string, error) {
+func (s *service) ReservationClosest(ctx context.Context, from time.Time, to time.Time, location Location) (Reservation, string, error) {
 	reservation := Reservation{}
-	secret, _ := getConsulValue(s.consul, s.logger, "jwtSecret")
-	token := strings.Split(userToken, " ")
-	if len(token) != 2 {
-		return reservation, "Authorization failed", nil
-	}
-	userID, err := FromJWT(token[1], secret)
+	principal, err := principalFrom(ctx)
 	if err != nil {
-		return reservation, "Authorization failed", nil
+		return reservation, "Error", err
 	}
 	logger := log.With(s.logger, "method: ", "ReservationClosest")
-	reservation, err = s.db.ReservationClosest(ctx, userID, from, to, location)
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return reservation, "Error", err
+	}
+	reservation, err = s.db.ReservationClosest(ctx, principal.UserID, from, to, location)
 	if err != nil {
 		level.Error(logger).Log("err", err)
 		return reservation, "Error", err
@@ -113,13 +164,306 @@ func (s service) ReservationClosest(ctx context.Context, userToken string, from
 	logger.Log("reserve Closest")
 	return reservation, "Ok", nil
 }
-func FromJWT(token string, secret string) (string, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+func (s *service) SearchChargers(ctx context.Context, from time.Time, to time.Time, location Location, limit int) ([]ChargerCandidate, error) {
+	logger := log.With(s.logger, "method: ", "SearchChargers")
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+	candidates, err := s.db.FindChargers(ctx, from, to, location)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, err
+	}
+	logger.Log("search Chargers", len(candidates))
+	return rankCandidates(candidates, s.scorer, limit), nil
+}
+func (s *service) TransitionReservation(ctx context.Context, id string, to ReservationState) (Reservation, string, error) {
+	logger := log.With(s.logger, "method: ", "TransitionReservation")
+	principal, err := principalFrom(ctx)
+	if err != nil {
+		return Reservation{}, "Error", err
+	}
+	current, err := s.db.GetReservation(ctx, id)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return current, "Error", err
+	}
+	if err := s.authorize(ctx, transitionActions[to], current); err != nil {
+		return current, "Error", err
+	}
+	if !CanTransition(current.State, to) {
+		return current, "Error", ErrIllegalTransition
+	}
+	transition := StateTransition{
+		From:  current.State,
+		To:    to,
+		At:    time.Now().UTC(),
+		Actor: principal.UserID,
+	}
+	reservation, err := s.db.UpdateReservationState(ctx, id, current.State, transition)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return reservation, "Error", err
+	}
+	logger.Log("transition Reservation", id, "state", to)
+	return reservation, "Ok", nil
+}
+
+func (s *service) GetAvailability(ctx context.Context, chargerID string, from time.Time, to time.Time, slot time.Duration) (Availability, error) {
+	logger := log.With(s.logger, "method", "GetAvailability")
+	availability := Availability{ChargerID: chargerID, From: from, To: to}
+	if !from.Before(to) || to.Sub(from) > MaxAvailabilityRange || slot < 0 {
+		return availability, ErrInvalidRange
+	}
+	reservations, err := s.db.GetReservationsFilter(ctx, ReservationFilter{
+		ChargerID: chargerID,
+		States:    activeStates,
+		From:      from,
+		To:        to,
 	})
 	if err != nil {
-		return "", err
+		level.Error(logger).Log("err", err)
+		return availability, err
+	}
+	availability.Free, availability.Busy = computeAvailability(from, to, slot, reservations)
+	logger.Log("Get Availability", chargerID)
+	return availability, nil
+}
+
+func (s *service) CreateRecurringReservation(ctx context.Context, chargerID string, from time.Time, to time.Time, rrule string) (ReservationSeries, []OccurrenceResult, string, error) {
+	logger := log.With(s.logger, "method: ", "CreateRecurringReservation")
+	series := ReservationSeries{}
+	principal, err := principalFrom(ctx)
+	if err != nil {
+		return series, nil, "Error", err
+	}
+	rule, err := ParseRRule(rrule)
+	if err != nil {
+		return series, nil, "Error", invalidArgument(err)
+	}
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return series, nil, "Error", err
+	}
+	occurrences := expandSeries(rule, from, to)
+	if len(occurrences) == 0 {
+		return series, nil, "Error", ErrNoOccurrences
+	}
+	if series.UserID, err = primitive.ObjectIDFromHex(principal.UserID); err != nil {
+		return series, nil, "Error", invalidID("userID", principal.UserID)
+	}
+	if series.ChargerID, err = primitive.ObjectIDFromHex(chargerID); err != nil {
+		return series, nil, "Error", invalidID("chargerID", chargerID)
+	}
+	if err := s.chargers.Check(ctx, series.ChargerID); err != nil {
+		level.Error(logger).Log("err", err)
+		return series, nil, "Error", err
+	}
+	series.RRule = rrule
+	series.From = from
+	series.To = to
+	series.Created = time.Now().UTC()
+	series, results, err := s.db.CreateSeries(ctx, series, occurrences)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return series, results, "Error", err
+	}
+	logger.Log("create RecurringReservation", series.ID.Hex())
+	return series, results, "Ok", nil
+}
+
+// UpdateSeries moves the occurrences selected by scope, relative to the
+// reservation id, by the same offset as id and gives them its new length.
+// With ScopeFollowing the moved occurrences become a series of their own and
+// the original series ends before id.
+func (s *service) UpdateSeries(ctx context.Context, id string, from time.Time, to time.Time, scope SeriesScope) ([]OccurrenceResult, error) {
+	logger := log.With(s.logger, "method: ", "UpdateSeries")
+	if err := validateWindow(from, to, time.Now()); err != nil {
+		return nil, err
+	}
+	targets, err := s.selectSeries(ctx, id, scope)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, err
+	}
+	if err := s.authorize(ctx, ActionUpdate, targets.current); err != nil {
+		return nil, err
+	}
+	if err := s.chargers.Check(ctx, targets.current.ChargerID); err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, err
+	}
+	current := targets.current
+	shift := from.Sub(current.From)
+	duration := to.Sub(from)
+	results := []OccurrenceResult{}
+	for _, occurrence := range moveOrder(targets.occurrences, shift) {
+		newFrom := occurrence.From.Add(shift)
+		result := OccurrenceResult{
+			ReservationID: occurrence.ID.Hex(),
+			From:          newFrom,
+			To:            newFrom.Add(duration),
+			Status:        OccurrenceUpdated,
+		}
+		err := validateWindow(result.From, result.To, time.Now())
+		if err == nil && !CanReschedule(occurrence.State) {
+			err = ErrNotReschedulable
+		}
+		if err == nil {
+			err = s.db.UpdateReservation(ctx, result.ReservationID, result.From, result.To, AnyVersion)
+		}
+		if err != nil {
+			result.Status = OccurrenceFailed
+			if errors.Is(err, ErrReservationConflict) {
+				result.Status = OccurrenceConflict
+			}
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].From.Before(results[j].From) })
+	if scope == ScopeThis || current.SeriesID.IsZero() {
+		logger.Log("update Series", id, "scope", scope)
+		return results, nil
+	}
+	// The series record only follows the occurrences when all of them moved;
+	// otherwise it keeps describing where the others still are.
+	moved := []string{}
+	for _, result := range results {
+		if result.Status != OccurrenceUpdated {
+			logger.Log("update Series", id, "scope", scope, "series", "unchanged")
+			return results, nil
+		}
+		moved = append(moved, result.ReservationID)
+	}
+	if scope == ScopeAll {
+		err = s.db.MoveSeries(ctx, current.SeriesID.Hex(), shift, duration)
+	} else {
+		_, err = s.db.SplitSeries(ctx, current.SeriesID.Hex(), current.From, shift, duration, moved)
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return results, err
+	}
+	logger.Log("update Series", id, "scope", scope)
+	return results, nil
+}
+
+// moveOrder sorts occurrences so the one furthest in the direction of shift
+// moves first. Each occurrence then lands on a slot its siblings have
+// already left, and only overlaps a sibling if the moved series overlaps
+// itself.
+func moveOrder(occurrences []Reservation, shift time.Duration) []Reservation {
+	sorted := append([]Reservation{}, occurrences...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if shift > 0 {
+			return sorted[i].From.After(sorted[j].From)
+		}
+		return sorted[i].From.Before(sorted[j].From)
+	})
+	return sorted
+}
+
+// CancelSeries cancels the occurrences selected by scope, relative to the
+// reservation id.
+func (s *service) CancelSeries(ctx context.Context, id string, scope SeriesScope) ([]OccurrenceResult, string, error) {
+	logger := log.With(s.logger, "method: ", "CancelSeries")
+	if _, err := principalFrom(ctx); err != nil {
+		return nil, "Error", err
+	}
+	targets, err := s.selectSeries(ctx, id, scope)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, "Error", err
+	}
+	if err := s.authorize(ctx, ActionCancel, targets.current); err != nil {
+		return nil, "Error", err
+	}
+	results := []OccurrenceResult{}
+	for _, occurrence := range targets.occurrences {
+		result := OccurrenceResult{
+			ReservationID: occurrence.ID.Hex(),
+			From:          occurrence.From,
+			To:            occurrence.To,
+			Status:        OccurrenceCancelled,
+		}
+		_, status, err := s.TransitionReservation(ctx, result.ReservationID, StateCancelled)
+		if err != nil {
+			result.Status = OccurrenceFailed
+			result.Error = err.Error()
+		} else if status != "Ok" {
+			result.Status = OccurrenceFailed
+			result.Error = status
+		}
+		results = append(results, result)
+	}
+	logger.Log("cancel Series", id, "scope", scope)
+	return results, "Ok", nil
+}
+
+// authorize asks the policy whether the caller may perform action on
+// reservation.
+func (s *service) authorize(ctx context.Context, action Action, reservation Reservation) error {
+	principal, err := principalFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return s.policy.Authorize(ctx, principal, action, reservation)
+}
+
+// scopeFilter restricts a listing to what the caller may see.
+func (s *service) scopeFilter(ctx context.Context, filter ReservationFilter) (ReservationFilter, error) {
+	principal, err := principalFrom(ctx)
+	if err != nil {
+		return filter, err
+	}
+	return s.policy.ScopeFilter(ctx, principal, filter)
+}
+
+type seriesSelection struct {
+	current     Reservation
+	occurrences []Reservation
+}
+
+// selectSeries loads the reservation id and the occurrences of its series
+// selected by scope.
+func (s *service) selectSeries(ctx context.Context, id string, scope SeriesScope) (seriesSelection, error) {
+	selection := seriesSelection{}
+	current, err := s.db.GetReservation(ctx, id)
+	if err != nil {
+		return selection, err
+	}
+	selection.current = current
+	occurrences := []Reservation{}
+	if scope != ScopeThis && !current.SeriesID.IsZero() {
+		occurrences, err = s.db.GetReservationsFilter(ctx, ReservationFilter{
+			SeriesID: current.SeriesID.Hex(),
+			States:   activeStates,
+		})
+		if err != nil {
+			return selection, err
+		}
+	}
+	selection.occurrences = seriesTargets(current, occurrences, scope, time.Now())
+	return selection, nil
+}
+
+// validateWindow checks that [from, to) is a sensible reservation window as
+// of now.
+func validateWindow(from time.Time, to time.Time, now time.Time) error {
+	if !from.Before(to) {
+		return ErrWindowReversed
+	}
+	if !from.After(now) {
+		return ErrWindowInPast
+	}
+	if to.Sub(from) > MaxReservationDuration {
+		return ErrWindowTooLong
 	}
-	return claims["user_id"].(string), nil
+	return nil
 }
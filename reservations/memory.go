@@ -0,0 +1,431 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryDatabase is a ReservationDB kept in process memory. It follows the
+// behaviour of the Mongo database, including its millisecond timestamps, and
+// is meant for tests and local development.
+type memoryDatabase struct {
+	mu              sync.RWMutex
+	reservations    map[primitive.ObjectID]Reservation
+	series          map[primitive.ObjectID]ReservationSeries
+	logger          log.Logger
+	chargers        *ChargerIndex
+	maxSearchRadius float64
+}
+
+func NewMemoryDatabase(logger log.Logger, chargers *ChargerIndex, maxSearchRadius float64) ReservationDB {
+	return &memoryDatabase{
+		reservations:    map[primitive.ObjectID]Reservation{},
+		series:          map[primitive.ObjectID]ReservationSeries{},
+		logger:          log.With(logger, "database", "memory"),
+		chargers:        chargers,
+		maxSearchRadius: maxSearchRadius,
+	}
+}
+
+func (mem *memoryDatabase) CreateReservation(ctx context.Context, from time.Time, to time.Time, userID string, chargerID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return invalidID("userID", userID)
+	}
+	chargerObjectID, err := primitive.ObjectIDFromHex(chargerID)
+	if err != nil {
+		return invalidID("chargerID", chargerID)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	_, err = mem.insert(newReservation(userObjectID, chargerObjectID, from, to, time.Now().UTC()))
+	return err
+}
+
+func (mem *memoryDatabase) GetReservation(ctx context.Context, id string) (Reservation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Reservation{}, invalidID("id", id)
+	}
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	reservation, ok := mem.reservations[objectID]
+	if !ok {
+		return Reservation{}, ErrReservationNotFound
+	}
+	return cloneReservation(reservation), nil
+}
+
+func (mem *memoryDatabase) GetReservations(ctx context.Context, states []ReservationState) ([]Reservation, error) {
+	return mem.GetReservationsFilter(ctx, ReservationFilter{States: states})
+}
+
+func (mem *memoryDatabase) GetReservationsFilter(ctx context.Context, filter ReservationFilter) ([]Reservation, error) {
+	match, err := compileFilter(filter)
+	if err != nil {
+		return []Reservation{}, err
+	}
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	found := []Reservation{}
+	for _, reservation := range mem.reservations {
+		if match(reservation) {
+			found = append(found, cloneReservation(reservation))
+		}
+	}
+	// Mongo returns documents in insertion order, which ids follow.
+	sort.Slice(found, func(i, j int) bool { return found[i].ID.Hex() < found[j].ID.Hex() })
+	return found, nil
+}
+
+func (mem *memoryDatabase) GetReservationsPage(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error) {
+	result := ReservationPage{Reservations: []Reservation{}}
+	page = page.normalize()
+	cursor, err := page.cursor()
+	if err != nil {
+		return result, err
+	}
+	found, err := mem.GetReservationsFilter(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return compareSortKey(found[i], sortValue(found[j], page.Sort), found[j].ID, page) < 0
+	})
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(found), func(i int) bool {
+			return compareSortKey(found[i], cursor.Value, cursor.ID, page) > 0
+		})
+	}
+	end := start + page.Limit + 1
+	if end > len(found) {
+		end = len(found)
+	}
+	return pageOf(found[start:end], page), nil
+}
+
+// compareSortKey orders reservation against the sort key (value, id) in the
+// direction page asks for.
+func compareSortKey(reservation Reservation, value time.Time, id primitive.ObjectID, page PageRequest) int {
+	own := sortValue(reservation, page.Sort)
+	cmp := 0
+	switch {
+	case own.Before(value):
+		cmp = -1
+	case own.After(value):
+		cmp = 1
+	case reservation.ID.Hex() < id.Hex():
+		cmp = -1
+	case reservation.ID.Hex() > id.Hex():
+		cmp = 1
+	}
+	if page.Descending {
+		return -cmp
+	}
+	return cmp
+}
+
+func (mem *memoryDatabase) UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersion int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return invalidID("id", id)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	current, ok := mem.reservations[objectID]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	from, to = storedTime(from), storedTime(to)
+	if err := mem.checkOverlap(current.ChargerID, from, to, objectID); err != nil {
+		return err
+	}
+	if !versionMatches(current.Version, expectedVersion) {
+		return ErrVersionMismatch
+	}
+	current.From = from
+	current.To = to
+	current.Modified = storedTime(time.Now())
+	current.Version++
+	mem.reservations[objectID] = current
+	return nil
+}
+
+func (mem *memoryDatabase) UpdateReservationState(ctx context.Context, id string, expected ReservationState, transition StateTransition) (Reservation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Reservation{}, invalidID("id", id)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	current, ok := mem.reservations[objectID]
+	if !ok {
+		return Reservation{}, ErrReservationNotFound
+	}
+	if current.State != expected {
+		return Reservation{}, ErrIllegalTransition
+	}
+	transition.At = storedTime(transition.At)
+	current = cloneReservation(current)
+	current.State = transition.To
+	current.Modified = transition.At
+	current.History = append(current.History, transition)
+	current.Version++
+	mem.reservations[objectID] = current
+	return cloneReservation(current), nil
+}
+
+func (mem *memoryDatabase) DeleteReservation(ctx context.Context, id string, expectedVersion int64) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return invalidID("id", id)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	current, ok := mem.reservations[objectID]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	if !versionMatches(current.Version, expectedVersion) {
+		return ErrVersionMismatch
+	}
+	delete(mem.reservations, objectID)
+	return nil
+}
+
+func (mem *memoryDatabase) CreateSeries(ctx context.Context, series ReservationSeries, occurrences []Interval) (ReservationSeries, []OccurrenceResult, error) {
+	results := []OccurrenceResult{}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	series.ID = primitive.NewObjectID()
+	mem.series[series.ID] = series
+	for _, occurrence := range occurrences {
+		reservation := newReservation(series.UserID, series.ChargerID, occurrence.From, occurrence.To, series.Created)
+		reservation.SeriesID = series.ID
+		result := OccurrenceResult{From: occurrence.From, To: occurrence.To, Status: OccurrenceBooked}
+		id, err := mem.insert(reservation)
+		switch {
+		case errors.Is(err, ErrReservationConflict):
+			result.Status = OccurrenceConflict
+			result.Error = err.Error()
+		case err != nil:
+			return series, results, err
+		default:
+			result.ReservationID = id.Hex()
+		}
+		results = append(results, result)
+	}
+	return series, results, nil
+}
+
+func (mem *memoryDatabase) MoveSeries(ctx context.Context, id string, shift time.Duration, length time.Duration) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return invalidID("id", id)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	series, ok := mem.series[objectID]
+	if !ok {
+		return ErrSeriesNotFound
+	}
+	series.From = series.From.Add(shift)
+	series.To = series.From.Add(length)
+	mem.series[objectID] = series
+	return nil
+}
+
+func (mem *memoryDatabase) SplitSeries(ctx context.Context, id string, at time.Time, shift time.Duration, length time.Duration, occurrences []string) (ReservationSeries, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ReservationSeries{}, invalidID("id", id)
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	series, ok := mem.series[objectID]
+	if !ok {
+		return ReservationSeries{}, ErrSeriesNotFound
+	}
+	ended, following, err := splitSeries(series, at, shift, length)
+	if err != nil {
+		return following, err
+	}
+	following.ID = primitive.NewObjectID()
+	following.Created = storedTime(following.Created)
+	mem.series[objectID] = ended
+	mem.series[following.ID] = following
+	for _, occurrence := range occurrences {
+		occurrenceID, err := primitive.ObjectIDFromHex(occurrence)
+		if err != nil {
+			continue
+		}
+		reservation, ok := mem.reservations[occurrenceID]
+		if !ok || reservation.SeriesID != objectID {
+			continue
+		}
+		reservation.SeriesID = following.ID
+		reservation.Modified = following.Created
+		reservation.Version++
+		mem.reservations[occurrenceID] = reservation
+	}
+	return following, nil
+}
+
+func (mem *memoryDatabase) ReservationClosest(ctx context.Context, userID string, from time.Time, to time.Time, location Location) (Reservation, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Reservation{}, invalidID("userID", userID)
+	}
+	reservation, err := bookClosest(ctx, mem.chargers, location, mem.maxSearchRadius, newReservation(userObjectID, primitive.NilObjectID, from, to, time.Now().UTC()), func(r Reservation) (primitive.ObjectID, error) {
+		mem.mu.Lock()
+		defer mem.mu.Unlock()
+		return mem.insert(r)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNoChargerAvailable) {
+			mem.logger.Log("Error booking the closest charger: ", err.Error())
+		}
+		return reservation, err
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return cloneReservation(mem.reservations[reservation.ID]), nil
+}
+
+func (mem *memoryDatabase) FindChargers(ctx context.Context, from time.Time, to time.Time, location Location) ([]ChargerCandidate, error) {
+	nearby, err := mem.chargers.WithinRadius(ctx, location, mem.maxSearchRadius)
+	if err != nil {
+		mem.logger.Log("Error getting chargers: ", err.Error())
+		return nil, err
+	}
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	busy := map[primitive.ObjectID]bool{}
+	for _, reservation := range mem.reservations {
+		if !isReleased(reservation.State) && overlaps(from, to, reservation.From, reservation.To) {
+			busy[reservation.ChargerID] = true
+		}
+	}
+	return chargerCandidates(nearby, busy), nil
+}
+
+// insert stores reservation unless it overlaps another booking on the same
+// charger. The caller holds the write lock.
+func (mem *memoryDatabase) insert(reservation Reservation) (primitive.ObjectID, error) {
+	reservation = cloneReservation(reservation)
+	reservation.From = storedTime(reservation.From)
+	reservation.To = storedTime(reservation.To)
+	reservation.Created = storedTime(reservation.Created)
+	reservation.Modified = storedTime(reservation.Modified)
+	for i := range reservation.History {
+		reservation.History[i].At = storedTime(reservation.History[i].At)
+	}
+	if err := mem.checkOverlap(reservation.ChargerID, reservation.From, reservation.To, primitive.NilObjectID); err != nil {
+		return primitive.NilObjectID, err
+	}
+	reservation.ID = primitive.NewObjectID()
+	mem.reservations[reservation.ID] = reservation
+	return reservation.ID, nil
+}
+
+// checkOverlap returns ErrReservationConflict if any active reservation on
+// chargerID other than exclude intersects [from, to).
+func (mem *memoryDatabase) checkOverlap(chargerID primitive.ObjectID, from time.Time, to time.Time, exclude primitive.ObjectID) error {
+	for id, other := range mem.reservations {
+		if id == exclude || other.ChargerID != chargerID || isReleased(other.State) {
+			continue
+		}
+		if overlaps(from, to, other.From, other.To) {
+			return ErrReservationConflict
+		}
+	}
+	return nil
+}
+
+// compileFilter turns filter into a predicate with the semantics of
+// filterToBSON.
+func compileFilter(filter ReservationFilter) (func(Reservation) bool, error) {
+	chargers := map[primitive.ObjectID]bool{}
+	for _, id := range filter.ChargerIDs {
+		chargerID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, invalidID("charger", id)
+		}
+		chargers[chargerID] = true
+	}
+	var chargerID, userID, seriesID primitive.ObjectID
+	var err error
+	if filter.ChargerID != "" {
+		if chargerID, err = primitive.ObjectIDFromHex(filter.ChargerID); err != nil {
+			return nil, invalidID("charger", filter.ChargerID)
+		}
+	}
+	if filter.UserID != "" {
+		if userID, err = primitive.ObjectIDFromHex(filter.UserID); err != nil {
+			return nil, invalidID("user", filter.UserID)
+		}
+	}
+	if filter.SeriesID != "" {
+		if seriesID, err = primitive.ObjectIDFromHex(filter.SeriesID); err != nil {
+			return nil, invalidID("series", filter.SeriesID)
+		}
+	}
+	states := map[ReservationState]bool{}
+	for _, state := range filter.States {
+		states[state] = true
+	}
+	return func(r Reservation) bool {
+		switch {
+		case filter.ChargerID != "" && r.ChargerID != chargerID:
+			return false
+		case len(chargers) > 0 && !chargers[r.ChargerID]:
+			return false
+		case filter.UserID != "" && r.UserID != userID:
+			return false
+		case filter.SeriesID != "" && r.SeriesID != seriesID:
+			return false
+		case len(states) > 0 && !states[r.State]:
+			return false
+		case !filter.To.IsZero() && !r.From.Before(filter.To):
+			return false
+		case !filter.From.IsZero() && !r.To.After(filter.From):
+			return false
+		case !filter.CreatedFrom.IsZero() && r.Created.Before(filter.CreatedFrom):
+			return false
+		case !filter.CreatedTo.IsZero() && !r.Created.Before(filter.CreatedTo):
+			return false
+		}
+		return true
+	}, nil
+}
+
+// versionMatches applies the rules of versionFilter to a stored version.
+func versionMatches(version int64, expected int64) bool {
+	return expected == AnyVersion || version == expected
+}
+
+func isReleased(state ReservationState) bool {
+	for _, released := range releasedStates {
+		if state == released {
+			return true
+		}
+	}
+	return false
+}
+
+// storedTime rounds t the way a BSON date does.
+func storedTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+func cloneReservation(reservation Reservation) Reservation {
+	reservation.History = append([]StateTransition(nil), reservation.History...)
+	return reservation
+}
@@ -0,0 +1,13 @@
+package reservations
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestMemoryDatabase(t *testing.T) {
+	testReservationDB(t, func(t *testing.T, chargers *ChargerIndex) ReservationDB {
+		return NewMemoryDatabase(log.NewNopLogger(), chargers, conformanceRadius)
+	})
+}
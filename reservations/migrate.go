@@ -0,0 +1,268 @@
+package reservations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoMigration is one step in the evolution of the Mongo schema. Up must
+// be idempotent: if an instance dies halfway, another one runs the step
+// again from the start once the claim has gone stale.
+type MongoMigration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, logger log.Logger) error
+}
+
+// mongoMigrations are applied in order; new steps are only ever appended.
+var mongoMigrations = []MongoMigration{
+	{
+		ID:          "0001_indexes",
+		Description: "create the reservation and idempotency indexes",
+		Up: func(ctx context.Context, db *mongo.Database, logger log.Logger) error {
+			return EnsureMongoIndexes(ctx, db)
+		},
+	},
+	{
+		ID:          "0002_reservation_dates",
+		Description: "convert string timestamps of reservations to BSON dates",
+		Up:          migrateReservationDates,
+	},
+	{
+		ID:          "0003_reservation_defaults",
+		Description: "give reservations from before the lifecycle a state and version",
+		Up:          migrateReservationDefaults,
+	},
+}
+
+// mongoIndexes lists, per collection, the indexes the service relies on.
+var mongoIndexes = map[string][]mongo.IndexModel{
+	"Reservations": {
+		// Overlap checks and availability.
+		{Keys: bson.D{{Key: "chargerid", Value: 1}, {Key: "from", Value: 1}}, Options: options.Index().SetName("chargerid_from")},
+		// A user's reservations, paged by start time.
+		{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "from", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("userid_from")},
+		// Listings paged by start or creation time.
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("from_id")},
+		{Keys: bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("created_id")},
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "to", Value: 1}}, Options: options.Index().SetName("state_to")},
+		{Keys: bson.D{{Key: "seriesid", Value: 1}}, Options: options.Index().SetName("seriesid").SetSparse(true)},
+	},
+	"IdempotencyKeys": {
+		{Keys: bson.D{{Key: "expires", Value: 1}}, Options: options.Index().SetName("expires_1").SetExpireAfterSeconds(0)},
+	},
+}
+
+type migrationRecord struct {
+	ID          string    `bson:"_id"`
+	Description string    `bson:"description"`
+	Applied     bool      `bson:"applied"`
+	Started     time.Time `bson:"started"`
+	Finished    time.Time `bson:"finished,omitempty"`
+}
+
+// staleMigration is how long a claimed migration may run before other
+// instances assume its owner died and take it over.
+const staleMigration = 10 * time.Minute
+
+// MigrateMongo applies the migrations not yet recorded in the Migrations
+// collection. When several replicas start together, one runs each step and
+// the others wait for it.
+func MigrateMongo(ctx context.Context, db *mongo.Database, logger log.Logger) error {
+	logger = log.With(logger, "component", "migrations")
+	records := db.Collection("Migrations")
+	for _, migration := range mongoMigrations {
+		if err := applyMongoMigration(ctx, db, records, migration, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMongoMigration(ctx context.Context, db *mongo.Database, records *mongo.Collection, migration MongoMigration, logger log.Logger) error {
+	for {
+		record := migrationRecord{ID: migration.ID, Description: migration.Description, Started: time.Now().UTC()}
+		_, err := records.InsertOne(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			applied, err := waitForMigration(ctx, records, migration.ID)
+			if err != nil || applied {
+				return err
+			}
+			// The claim was abandoned; try to take it over.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logger.Log("msg", "applying migration", "id", migration.ID)
+		if err := migration.Up(ctx, db, logger); err != nil {
+			// Give the step back so the next start retries it.
+			records.DeleteOne(context.Background(), bson.M{"_id": migration.ID, "applied": false})
+			return fmt.Errorf("migration %s: %w", migration.ID, err)
+		}
+		_, err = records.UpdateOne(ctx, bson.M{"_id": migration.ID}, bson.M{"$set": bson.M{"applied": true, "finished": time.Now().UTC()}})
+		return err
+	}
+}
+
+// waitForMigration blocks until another instance has applied migration id,
+// or reports false once that instance has given up or gone stale.
+func waitForMigration(ctx context.Context, records *mongo.Collection, id string) (bool, error) {
+	for {
+		record := migrationRecord{}
+		err := records.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if record.Applied {
+			return true, nil
+		}
+		if time.Since(record.Started) > staleMigration {
+			_, err := records.DeleteOne(ctx, bson.M{"_id": id, "applied": false, "started": record.Started})
+			return false, err
+		}
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("waiting for migration %s: %w", id, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// EnsureMongoIndexes creates the indexes in mongoIndexes. Creating an index
+// that already exists with the same definition is a no-op.
+func EnsureMongoIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, collection := range sortedCollections() {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, mongoIndexes[collection]); err != nil {
+			return fmt.Errorf("indexes of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// VerifyMongoIndexes reports the indexes in mongoIndexes that are missing.
+func VerifyMongoIndexes(ctx context.Context, db *mongo.Database) error {
+	missing := []string{}
+	for _, collection := range sortedCollections() {
+		cursor, err := db.Collection(collection).Indexes().List(ctx)
+		if err != nil {
+			return err
+		}
+		existing := []bson.M{}
+		if err := cursor.All(ctx, &existing); err != nil {
+			return err
+		}
+		names := map[string]bool{}
+		for _, index := range existing {
+			if name, ok := index["name"].(string); ok {
+				names[name] = true
+			}
+		}
+		for _, index := range mongoIndexes[collection] {
+			if name := *index.Options.Name; !names[name] {
+				missing = append(missing, collection+"."+name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func sortedCollections() []string {
+	collections := []string{}
+	for collection := range mongoIndexes {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// migrateReservationDates rewrites string from, to, created and modified
+// fields as dates. Each update is conditional on the string it replaces, so
+// a concurrent write to the same reservation wins.
+func migrateReservationDates(ctx context.Context, db *mongo.Database, logger log.Logger) error {
+	collection := db.Collection("Reservations")
+	legacy := bson.A{}
+	for field := range reservationTimeFields {
+		legacy = append(legacy, bson.M{field: bson.M{"$type": "string"}})
+	}
+	cursor, err := collection.Find(ctx, bson.M{"$or": legacy})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	converted, skipped := 0, 0
+	for cursor.Next(ctx) {
+		doc := bson.D{}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		filter := bson.M{}
+		set := bson.M{}
+		for _, elem := range doc {
+			if elem.Key == "_id" {
+				filter["_id"] = elem.Value
+			}
+			str, ok := elem.Value.(string)
+			if !ok || !reservationTimeFields[elem.Key] {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				logger.Log("msg", "cannot convert reservation timestamp", "id", filter["_id"], "field", elem.Key, "err", err)
+				continue
+			}
+			filter[elem.Key] = str
+			set[elem.Key] = t
+		}
+		if len(set) == 0 {
+			skipped++
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": set}); err != nil {
+			return err
+		}
+		converted++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	logger.Log("msg", "converted reservation timestamps", "converted", converted, "skipped", skipped)
+	return nil
+}
+
+// migrateReservationDefaults stores the state and version that the decoder
+// and versionFilter already assume for old documents, so queries no longer
+// need to special-case missing fields.
+func migrateReservationDefaults(ctx context.Context, db *mongo.Database, logger log.Logger) error {
+	collection := db.Collection("Reservations")
+	states, err := collection.UpdateMany(ctx,
+		bson.M{"state": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"state": StatePending, "history": bson.A{}}},
+	)
+	if err != nil {
+		return err
+	}
+	versions, err := collection.UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": 0}},
+	)
+	if err != nil {
+		return err
+	}
+	logger.Log("msg", "filled reservation defaults", "state", states.ModifiedCount, "version", versions.ModifiedCount)
+	return nil
+}
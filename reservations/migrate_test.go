@@ -0,0 +1,184 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMongoMigrationsOrdered(t *testing.T) {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, migration := range mongoMigrations {
+		if seen[migration.ID] {
+			t.Errorf("duplicate migration %s", migration.ID)
+		}
+		if migration.Up == nil {
+			t.Errorf("migration %s has no Up", migration.ID)
+		}
+		seen[migration.ID] = true
+		ids = append(ids, migration.ID)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("migrations out of order: %v", ids)
+	}
+}
+
+func TestMongoIndexesNamed(t *testing.T) {
+	for collection, indexes := range mongoIndexes {
+		names := map[string]bool{}
+		for _, index := range indexes {
+			if index.Options == nil || index.Options.Name == nil {
+				t.Errorf("%s has an unnamed index %v", collection, index.Keys)
+				continue
+			}
+			if names[*index.Options.Name] {
+				t.Errorf("%s has two indexes named %s", collection, *index.Options.Name)
+			}
+			names[*index.Options.Name] = true
+		}
+	}
+}
+
+// countingMigration counts how often it runs and fails while fail is set.
+func countingMigration(runs *int, fail *bool) MongoMigration {
+	return MongoMigration{
+		ID:          "9999_test",
+		Description: "test step",
+		Up: func(ctx context.Context, db *mongo.Database, logger log.Logger) error {
+			*runs++
+			if *fail {
+				return errors.New("step failed")
+			}
+			return nil
+		},
+	}
+}
+
+func TestApplyMongoMigration(t *testing.T) {
+	client := connectTestMongo(t)
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	t.Run("ClaimOnce", func(t *testing.T) {
+		db := throwawayMongo(t, client)
+		records := db.Collection("Migrations")
+		runs, fail := 0, false
+		migration := countingMigration(&runs, &fail)
+		for i := 0; i < 2; i++ {
+			if err := applyMongoMigration(ctx, db, records, migration, logger); err != nil {
+				t.Fatal(err)
+			}
+		}
+		record := migrationRecord{}
+		if err := records.FindOne(ctx, bson.M{"_id": migration.ID}).Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if runs != 1 || !record.Applied || record.Finished.IsZero() {
+			t.Errorf("ran %d times, record %+v", runs, record)
+		}
+	})
+
+	t.Run("ReleaseOnFailure", func(t *testing.T) {
+		db := throwawayMongo(t, client)
+		records := db.Collection("Migrations")
+		runs, fail := 0, true
+		migration := countingMigration(&runs, &fail)
+		if err := applyMongoMigration(ctx, db, records, migration, logger); err == nil {
+			t.Fatal("failing step reported success")
+		}
+		if n, _ := records.CountDocuments(ctx, bson.M{"_id": migration.ID}); n != 0 {
+			t.Errorf("failed step left its claim behind")
+		}
+		fail = false
+		if err := applyMongoMigration(ctx, db, records, migration, logger); err != nil || runs != 2 {
+			t.Errorf("retry after failure: %v, ran %d times", err, runs)
+		}
+	})
+
+	t.Run("StaleTakeover", func(t *testing.T) {
+		db := throwawayMongo(t, client)
+		records := db.Collection("Migrations")
+		runs, fail := 0, false
+		migration := countingMigration(&runs, &fail)
+		stale := migrationRecord{ID: migration.ID, Started: time.Now().UTC().Add(-2 * staleMigration)}
+		if _, err := records.InsertOne(ctx, stale); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyMongoMigration(ctx, db, records, migration, logger); err != nil || runs != 1 {
+			t.Errorf("taking over a stale claim: %v, ran %d times", err, runs)
+		}
+	})
+
+	t.Run("WaitForOwner", func(t *testing.T) {
+		db := throwawayMongo(t, client)
+		records := db.Collection("Migrations")
+		runs, fail := 0, false
+		migration := countingMigration(&runs, &fail)
+		if _, err := records.InsertOne(ctx, migrationRecord{ID: migration.ID, Started: time.Now().UTC()}); err != nil {
+			t.Fatal(err)
+		}
+		short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		if applied, err := waitForMigration(short, records, migration.ID); applied || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("waiting on a live claim: %v, %v", applied, err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- applyMongoMigration(ctx, db, records, migration, logger) }()
+		if _, err := records.UpdateOne(ctx, bson.M{"_id": migration.ID}, bson.M{"$set": bson.M{"applied": true}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil || runs != 0 {
+			t.Errorf("waiting for another instance: %v, ran %d times", err, runs)
+		}
+	})
+}
+
+func TestMigrateReservationDates(t *testing.T) {
+	db := throwawayMongo(t, connectTestMongo(t))
+	ctx := context.Background()
+	collection := db.Collection("Reservations")
+	from := time.Date(2030, 1, 2, 10, 0, 0, 0, time.UTC)
+	docs := []interface{}{
+		bson.M{"_id": "legacy", "from": from.Format(time.RFC3339), "to": from.Add(time.Hour).Format(time.RFC3339), "created": from},
+		bson.M{"_id": "broken", "from": "yesterday", "to": from.Add(time.Hour)},
+		bson.M{"_id": "current", "from": from, "to": from.Add(time.Hour)},
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := migrateReservationDates(ctx, db, log.NewNopLogger()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(id string) bson.M {
+		doc := bson.M{}
+		err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	legacy := find("legacy")
+	for _, field := range []string{"from", "to", "created"} {
+		if _, ok := legacy[field].(primitive.DateTime); !ok {
+			t.Errorf("legacy %s is %T, want a date", field, legacy[field])
+		}
+	}
+	if got, ok := legacy["from"].(primitive.DateTime); ok && !got.Time().Equal(from) {
+		t.Errorf("legacy from converted to %v, want %v", got.Time(), from)
+	}
+	if broken := find("broken"); broken["from"] != "yesterday" {
+		t.Errorf("unparseable from was rewritten to %v", broken["from"])
+	}
+}
@@ -0,0 +1,38 @@
+-- Reservations and recurring series. A reservation occupies its charger for
+-- [starts_at, ends_at) until it reaches a released state; the exclusion
+-- constraint makes overlapping active bookings on one charger impossible.
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE reservation_series (
+    id         char(24)    PRIMARY KEY,
+    charger_id char(24)    NOT NULL,
+    user_id    char(24)    NOT NULL,
+    rrule      text        NOT NULL,
+    starts_at  timestamptz NOT NULL,
+    ends_at    timestamptz NOT NULL,
+    created    timestamptz NOT NULL
+);
+
+CREATE TABLE reservations (
+    id         char(24)    PRIMARY KEY,
+    charger_id char(24)    NOT NULL,
+    user_id    char(24)    NOT NULL,
+    series_id  char(24)    REFERENCES reservation_series (id) ON DELETE SET NULL,
+    starts_at  timestamptz NOT NULL,
+    ends_at    timestamptz NOT NULL,
+    state      text        NOT NULL,
+    history    jsonb       NOT NULL DEFAULT '[]',
+    version    bigint      NOT NULL DEFAULT 1,
+    created    timestamptz NOT NULL,
+    modified   timestamptz NOT NULL,
+    CHECK (starts_at < ends_at),
+    CONSTRAINT reservations_no_overlap EXCLUDE USING gist (
+        charger_id WITH =,
+        tstzrange(starts_at, ends_at, '[)') WITH &&
+    ) WHERE (state NOT IN ('completed', 'cancelled', 'no-show', 'expired'))
+);
+
+CREATE INDEX reservations_user_starts ON reservations (user_id, starts_at, id);
+CREATE INDEX reservations_starts ON reservations (starts_at, id);
+CREATE INDEX reservations_created ON reservations (created, id);
+CREATE INDEX reservations_series ON reservations (series_id);
@@ -0,0 +1,12 @@
+-- Stored responses for requests made with an Idempotency-Key header.
+CREATE TABLE idempotency_keys (
+    id          text        PRIMARY KEY,
+    user_id     text        NOT NULL,
+    key         text        NOT NULL,
+    fingerprint text        NOT NULL,
+    completed   boolean     NOT NULL DEFAULT false,
+    response    bytea,
+    expires     timestamptz NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires ON idempotency_keys (expires);
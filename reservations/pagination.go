@@ -0,0 +1,127 @@
+package reservations
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SortField is the reservation timestamp a listing is ordered by. Ties are
+// broken by reservation id.
+type SortField string
+
+const (
+	SortByFrom    SortField = "from"
+	SortByCreated SortField = "created"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// PageRequest selects one page of a reservation listing. Cursor is the
+// NextCursor of the previous page, or empty for the first one.
+type PageRequest struct {
+	Sort       SortField
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+// ReservationPage is one page of a listing. NextCursor is empty on the last
+// page.
+type ReservationPage struct {
+	Reservations []Reservation
+	NextCursor   string
+}
+
+// pageCursor marks the last reservation of a page by its sort key and id.
+// Pages continue strictly after that key, so reservations inserted while a
+// client is paging never shift the pages it has not read yet.
+type pageCursor struct {
+	Sort       SortField          `json:"s"`
+	Descending bool               `json:"d,omitempty"`
+	Value      time.Time          `json:"v"`
+	ID         primitive.ObjectID `json:"id"`
+}
+
+// ParseSort parses a sort parameter such as "from" or "-created". A leading
+// minus sorts newest first; empty means "from".
+func ParseSort(s string) (SortField, bool, error) {
+	descending := strings.HasPrefix(s, "-")
+	switch field := SortField(strings.TrimPrefix(s, "-")); field {
+	case "":
+		return SortByFrom, false, nil
+	case SortByFrom, SortByCreated:
+		return field, descending, nil
+	}
+	return "", false, fmt.Errorf("unknown sort %q", s)
+}
+
+// normalize fills in the defaults of page.
+func (page PageRequest) normalize() PageRequest {
+	if page.Sort == "" {
+		page.Sort = SortByFrom
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+	return page
+}
+
+// cursor decodes page.Cursor, or returns nil for the first page.
+func (page PageRequest) cursor() (*pageCursor, error) {
+	if page.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := pageCursor{}
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != page.Sort || cursor.Descending != page.Descending {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func sortValue(reservation Reservation, field SortField) time.Time {
+	if field == SortByCreated {
+		return reservation.Created
+	}
+	return reservation.From
+}
+
+// pageOf builds a page from up to page.Limit+1 sorted reservations; the
+// extra one only signals that another page exists.
+func pageOf(reservations []Reservation, page PageRequest) ReservationPage {
+	result := ReservationPage{Reservations: reservations}
+	if len(reservations) <= page.Limit {
+		return result
+	}
+	result.Reservations = reservations[:page.Limit]
+	last := result.Reservations[page.Limit-1]
+	result.NextCursor = encodeCursor(pageCursor{
+		Sort:       page.Sort,
+		Descending: page.Descending,
+		Value:      sortValue(last, page.Sort),
+		ID:         last.ID,
+	})
+	return result
+}
@@ -0,0 +1,82 @@
+package reservations
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseSort(t *testing.T) {
+	cases := map[string]struct {
+		field      SortField
+		descending bool
+	}{
+		"":         {SortByFrom, false},
+		"from":     {SortByFrom, false},
+		"-created": {SortByCreated, true},
+	}
+	for in, want := range cases {
+		field, descending, err := ParseSort(in)
+		if err != nil || field != want.field || descending != want.descending {
+			t.Errorf("%q: got %s %v %v", in, field, descending, err)
+		}
+	}
+	if _, _, err := ParseSort("userid"); err == nil {
+		t.Error("unknown sort field accepted")
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	start := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)
+	reservations := []Reservation{}
+	for i := 0; i < 3; i++ {
+		reservations = append(reservations, Reservation{ID: primitive.NewObjectID(), From: start.Add(time.Duration(i) * time.Hour)})
+	}
+	page := PageRequest{Limit: 2}.normalize()
+
+	first := pageOf(reservations, page)
+	if len(first.Reservations) != 2 || first.NextCursor == "" {
+		t.Fatalf("got %d reservations, cursor %q", len(first.Reservations), first.NextCursor)
+	}
+	if last := pageOf(reservations[:2], page); last.NextCursor != "" {
+		t.Error("a page without more results must not have a cursor")
+	}
+
+	page.Cursor = first.NextCursor
+	cursor, err := page.cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.Value.Equal(reservations[1].From) || cursor.ID != reservations[1].ID {
+		t.Errorf("cursor points at %v %s", cursor.Value, cursor.ID.Hex())
+	}
+
+	page.Sort = SortByCreated
+	if _, err := page.cursor(); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor reused with another sort: got %v", err)
+	}
+	page.Cursor = "garbage"
+	if _, err := page.cursor(); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("malformed cursor: got %v", err)
+	}
+}
+
+func TestFilterToBSONOpenBounds(t *testing.T) {
+	since := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, err := filterToBSON(ReservationFilter{From: since, CreatedTo: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := query["from"]; ok {
+		t.Error("open upper bound must not constrain from")
+	}
+	if got := query["to"]; got.(bson.M)["$gt"] != since {
+		t.Errorf("got to filter %v", got)
+	}
+	if got := query["created"]; got.(bson.M)["$lt"] != since {
+		t.Errorf("got created filter %v", got)
+	}
+}
@@ -0,0 +1,141 @@
+package reservations
+
+import "context"
+
+type Role string
+
+const (
+	RoleUser     Role = "user"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// Action is an operation a principal attempts on a reservation.
+type Action string
+
+const (
+	ActionRead     Action = "read"
+	ActionUpdate   Action = "update"
+	ActionDelete   Action = "delete"
+	ActionConfirm  Action = "confirm"
+	ActionCheckIn  Action = "check-in"
+	ActionComplete Action = "complete"
+	ActionCancel   Action = "cancel"
+	ActionNoShow   Action = "no-show"
+	ActionExpire   Action = "expire"
+)
+
+// transitionActions maps a target state to the action of moving there.
+var transitionActions = map[ReservationState]Action{
+	StateConfirmed: ActionConfirm,
+	StateCheckedIn: ActionCheckIn,
+	StateCompleted: ActionComplete,
+	StateCancelled: ActionCancel,
+	StateNoShow:    ActionNoShow,
+	StateExpired:   ActionExpire,
+}
+
+// Policy decides what an authenticated principal may do. The service asks
+// it before every read or write of an existing reservation and before every
+// listing.
+type Policy interface {
+	// Authorize returns ErrForbidden if principal may not perform action on
+	// reservation.
+	Authorize(ctx context.Context, principal Principal, action Action, reservation Reservation) error
+	// ScopeFilter narrows a listing filter to the reservations principal may
+	// see, or returns ErrForbidden if the filter asks for more than that.
+	ScopeFilter(ctx context.Context, principal Principal, filter ReservationFilter) (ReservationFilter, error)
+}
+
+// RolePolicy lets users manage their own reservations, lets operators see
+// and run the on-site lifecycle of reservations on chargers they manage, and
+// lets admins do everything. Only admins may delete a reservation; its owner
+// cancels it instead, which keeps it in the history.
+type RolePolicy struct{}
+
+// operatorActions are what an operator may do on reservations of chargers
+// they manage.
+var operatorActions = map[Action]bool{
+	ActionRead:     true,
+	ActionCheckIn:  true,
+	ActionComplete: true,
+	ActionNoShow:   true,
+	ActionExpire:   true,
+}
+
+// ownerActions are what a user may do on their own reservations. Checking in
+// and completing happen at the charger, so they are left to its operator.
+var ownerActions = map[Action]bool{
+	ActionRead:    true,
+	ActionUpdate:  true,
+	ActionConfirm: true,
+	ActionCancel:  true,
+}
+
+func (RolePolicy) Authorize(ctx context.Context, principal Principal, action Action, reservation Reservation) error {
+	switch {
+	case principal.HasRole(RoleAdmin):
+		return nil
+	case reservation.UserID.Hex() == principal.UserID && ownerActions[action]:
+		return nil
+	case principal.HasRole(RoleOperator) && principal.Manages(reservation.ChargerID.Hex()) && operatorActions[action]:
+		return nil
+	}
+	return ErrForbidden
+}
+
+func (RolePolicy) ScopeFilter(ctx context.Context, principal Principal, filter ReservationFilter) (ReservationFilter, error) {
+	if principal.HasRole(RoleAdmin) || filter.UserID == principal.UserID {
+		return filter, nil
+	}
+	if principal.HasRole(RoleOperator) {
+		if filter.ChargerID != "" {
+			if !principal.Manages(filter.ChargerID) {
+				return filter, ErrForbidden
+			}
+			return filter, nil
+		}
+		for _, id := range filter.ChargerIDs {
+			if !principal.Manages(id) {
+				return filter, ErrForbidden
+			}
+		}
+		if len(filter.ChargerIDs) == 0 {
+			if len(principal.Chargers) == 0 {
+				// An empty ChargerIDs means no restriction, so an operator
+				// without chargers may only see their own bookings.
+				if filter.UserID != "" {
+					return filter, ErrForbidden
+				}
+				filter.UserID = principal.UserID
+				return filter, nil
+			}
+			filter.ChargerIDs = principal.Chargers
+		}
+		return filter, nil
+	}
+	if filter.UserID != "" {
+		return filter, ErrForbidden
+	}
+	filter.UserID = principal.UserID
+	return filter, nil
+}
+
+func (p Principal) HasRole(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Manages reports whether chargerID is one of the operator's chargers.
+func (p Principal) Manages(chargerID string) bool {
+	for _, id := range p.Chargers {
+		if id == chargerID {
+			return true
+		}
+	}
+	return false
+}
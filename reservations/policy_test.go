@@ -0,0 +1,86 @@
+package reservations
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRolePolicyAuthorize(t *testing.T) {
+	owner := primitive.NewObjectID()
+	charger := primitive.NewObjectID()
+	reservation := Reservation{UserID: owner, ChargerID: charger}
+
+	user := Principal{UserID: owner.Hex(), Roles: []Role{RoleUser}}
+	stranger := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleUser}}
+	operator := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleOperator}, Chargers: []string{charger.Hex()}}
+	otherOperator := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleOperator}}
+	admin := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleAdmin}}
+
+	cases := []struct {
+		name      string
+		principal Principal
+		action    Action
+		allowed   bool
+	}{
+		{"owner updates", user, ActionUpdate, true},
+		{"owner marks no-show", user, ActionNoShow, false},
+		{"owner deletes", user, ActionDelete, false},
+		{"owner cancels", user, ActionCancel, true},
+		{"owner checks in", user, ActionCheckIn, false},
+		{"owner completes", user, ActionComplete, false},
+		{"operator checks in", operator, ActionCheckIn, true},
+		{"operator completes", operator, ActionComplete, true},
+		{"stranger reads", stranger, ActionRead, false},
+		{"operator reads", operator, ActionRead, true},
+		{"operator marks no-show", operator, ActionNoShow, true},
+		{"operator deletes", operator, ActionDelete, false},
+		{"other operator reads", otherOperator, ActionRead, false},
+		{"admin deletes", admin, ActionDelete, true},
+	}
+	for _, c := range cases {
+		err := RolePolicy{}.Authorize(context.Background(), c.principal, c.action, reservation)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s: got err %v, allowed %v", c.name, err, c.allowed)
+		}
+	}
+}
+
+func TestRolePolicyScopeFilter(t *testing.T) {
+	user := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleUser}}
+	filter, err := RolePolicy{}.ScopeFilter(context.Background(), user, ReservationFilter{})
+	if err != nil || filter.UserID != user.UserID {
+		t.Fatalf("user listing not restricted to own reservations: %+v, %v", filter, err)
+	}
+	if _, err := (RolePolicy{}).ScopeFilter(context.Background(), user, ReservationFilter{UserID: primitive.NewObjectID().Hex()}); err != ErrForbidden {
+		t.Fatalf("user listed someone else's reservations: %v", err)
+	}
+
+	charger := primitive.NewObjectID().Hex()
+	foreignUser := primitive.NewObjectID().Hex()
+	operator := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleOperator}, Chargers: []string{charger}}
+	idle := Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleOperator}}
+
+	if _, err := (RolePolicy{}).ScopeFilter(context.Background(), idle, ReservationFilter{UserID: foreignUser}); err != ErrForbidden {
+		t.Errorf("operator without chargers listed another user's reservations: %v", err)
+	}
+	filter, err = RolePolicy{}.ScopeFilter(context.Background(), idle, ReservationFilter{})
+	if err != nil || filter.UserID != idle.UserID || len(filter.ChargerIDs) != 0 {
+		t.Errorf("operator without chargers not restricted to own bookings: %+v, %v", filter, err)
+	}
+	if _, err := (RolePolicy{}).ScopeFilter(context.Background(), operator, ReservationFilter{ChargerID: primitive.NewObjectID().Hex()}); err != ErrForbidden {
+		t.Errorf("operator listed a charger they do not manage: %v", err)
+	}
+	if _, err := (RolePolicy{}).ScopeFilter(context.Background(), operator, ReservationFilter{ChargerIDs: []string{charger, primitive.NewObjectID().Hex()}}); err != ErrForbidden {
+		t.Errorf("operator listed a charger set with a foreign charger: %v", err)
+	}
+	filter, err = RolePolicy{}.ScopeFilter(context.Background(), operator, ReservationFilter{UserID: foreignUser})
+	if err != nil || filter.UserID != foreignUser || len(filter.ChargerIDs) != 1 || filter.ChargerIDs[0] != charger {
+		t.Errorf("operator listing of a user not limited to managed chargers: %+v, %v", filter, err)
+	}
+	filter, err = RolePolicy{}.ScopeFilter(context.Background(), operator, ReservationFilter{ChargerID: charger})
+	if err != nil || filter.ChargerID != charger {
+		t.Errorf("operator listing of a managed charger: %+v, %v", filter, err)
+	}
+}
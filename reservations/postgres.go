@@ -0,0 +1,623 @@
+package reservations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresMigrationLock is the advisory lock key that serialises replicas
+// migrating the same database.
+const postgresMigrationLock = 0x72657376
+
+// MigratePostgres applies, in file name order, the embedded migrations that
+// db has not recorded in schema_migrations yet.
+func MigratePostgres(ctx context.Context, db *sql.DB) error {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := applyPostgresMigration(ctx, db, name); err != nil {
+			return fmt.Errorf("postgres migration %s: %w", path.Base(name), err)
+		}
+	}
+	return nil
+}
+
+func applyPostgresMigration(ctx context.Context, db *sql.DB, name string) error {
+	script, err := postgresMigrations.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	version := strings.TrimSuffix(path.Base(name), ".sql")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version text PRIMARY KEY,
+		applied timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	applied := false
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresDatabase struct {
+	db              *sql.DB
+	logger          log.Logger
+	chargers        *ChargerIndex
+	maxSearchRadius float64
+}
+
+// NewPostgresDatabase stores reservations in PostgreSQL. The schema must
+// have been brought up to date with MigratePostgres.
+func NewPostgresDatabase(db *sql.DB, logger log.Logger, chargers *ChargerIndex, maxSearchRadius float64) ReservationDB {
+	return &postgresDatabase{
+		db:              db,
+		logger:          log.With(logger, "database", "postgres"),
+		chargers:        chargers,
+		maxSearchRadius: maxSearchRadius,
+	}
+}
+
+const reservationColumns = "id, charger_id, user_id, series_id, starts_at, ends_at, state, history, version, created, modified"
+
+func (pg *postgresDatabase) CreateReservation(ctx context.Context, from time.Time, to time.Time, userID string, chargerID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return invalidID("userID", userID)
+	}
+	chargerObjectID, err := primitive.ObjectIDFromHex(chargerID)
+	if err != nil {
+		return invalidID("chargerID", chargerID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = pg.insert(ctx, newReservation(userObjectID, chargerObjectID, from, to, time.Now().UTC()))
+	if err != nil && !errors.Is(err, ErrReservationConflict) {
+		pg.logger.Log("Error inserting reservation into DB: ", err.Error())
+	}
+	return err
+}
+
+func (pg *postgresDatabase) GetReservation(ctx context.Context, id string) (Reservation, error) {
+	if !primitive.IsValidObjectID(id) {
+		return Reservation{}, invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	row := pg.db.QueryRowContext(ctx, `SELECT `+reservationColumns+` FROM reservations WHERE id = $1`, id)
+	reservation, err := scanReservation(row)
+	if err == sql.ErrNoRows {
+		return reservation, ErrReservationNotFound
+	}
+	if err != nil {
+		pg.logger.Log("Error getting reservation from DB: ", err.Error())
+	}
+	return reservation, err
+}
+
+func (pg *postgresDatabase) GetReservations(ctx context.Context, states []ReservationState) ([]Reservation, error) {
+	return pg.GetReservationsFilter(ctx, ReservationFilter{States: states})
+}
+
+func (pg *postgresDatabase) GetReservationsFilter(ctx context.Context, filter ReservationFilter) ([]Reservation, error) {
+	where, args, err := postgresFilter(filter)
+	if err != nil {
+		return []Reservation{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return pg.query(ctx, `SELECT `+reservationColumns+` FROM reservations WHERE `+where+` ORDER BY id`, args...)
+}
+
+func (pg *postgresDatabase) GetReservationsPage(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error) {
+	result := ReservationPage{Reservations: []Reservation{}}
+	page = page.normalize()
+	cursor, err := page.cursor()
+	if err != nil {
+		return result, err
+	}
+	where, args, err := postgresFilter(filter)
+	if err != nil {
+		return result, err
+	}
+	column := "starts_at"
+	if page.Sort == SortByCreated {
+		column = "created"
+	}
+	op, direction := ">", "ASC"
+	if page.Descending {
+		op, direction = "<", "DESC"
+	}
+	if cursor != nil {
+		args = append(args, cursor.Value, cursor.ID.Hex())
+		where += fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", column, op, len(args)-1, len(args))
+	}
+	args = append(args, page.Limit+1)
+	query := fmt.Sprintf(`SELECT %s FROM reservations WHERE %s ORDER BY %s %s, id %s LIMIT $%d`,
+		reservationColumns, where, column, direction, direction, len(args))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	reservations, err := pg.query(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	return pageOf(reservations, page), nil
+}
+
+func (pg *postgresDatabase) UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersion int64) error {
+	if !primitive.IsValidObjectID(id) {
+		return invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := pg.db.ExecContext(ctx, `UPDATE reservations
+		SET starts_at = $2, ends_at = $3, modified = $4, version = version + 1
+		WHERE id = $1 AND ($5::bigint = -1 OR version = $5::bigint)`,
+		id, from, to, time.Now().UTC(), expectedVersion)
+	if err != nil {
+		err = postgresError(err)
+		if !errors.Is(err, ErrReservationConflict) {
+			pg.logger.Log("Error updating reservation: ", err.Error())
+		}
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return pg.missedVersion(ctx, id)
+	}
+	return nil
+}
+
+func (pg *postgresDatabase) UpdateReservationState(ctx context.Context, id string, expected ReservationState, transition StateTransition) (Reservation, error) {
+	if !primitive.IsValidObjectID(id) {
+		return Reservation{}, invalidID("id", id)
+	}
+	entry, err := json.Marshal([]StateTransition{transition})
+	if err != nil {
+		return Reservation{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	row := pg.db.QueryRowContext(ctx, `UPDATE reservations
+		SET state = $3, modified = $4, history = history || $5::jsonb, version = version + 1
+		WHERE id = $1 AND state = $2
+		RETURNING `+reservationColumns,
+		id, string(expected), string(transition.To), transition.At, string(entry))
+	reservation, err := scanReservation(row)
+	if err == sql.ErrNoRows {
+		if _, err := pg.GetReservation(ctx, id); err != nil {
+			return Reservation{}, err
+		}
+		return Reservation{}, ErrIllegalTransition
+	}
+	if err != nil {
+		err = postgresError(err)
+		pg.logger.Log("Error updating reservation state: ", err.Error())
+	}
+	return reservation, err
+}
+
+func (pg *postgresDatabase) DeleteReservation(ctx context.Context, id string, expectedVersion int64) error {
+	if !primitive.IsValidObjectID(id) {
+		return invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := pg.db.ExecContext(ctx, `DELETE FROM reservations WHERE id = $1 AND ($2::bigint = -1 OR version = $2::bigint)`, id, expectedVersion)
+	if err != nil {
+		pg.logger.Log("Error deleting reservation from DB: ", err.Error())
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return pg.missedVersion(ctx, id)
+	}
+	return nil
+}
+
+func (pg *postgresDatabase) CreateSeries(ctx context.Context, series ReservationSeries, occurrences []Interval) (ReservationSeries, []OccurrenceResult, error) {
+	results := []OccurrenceResult{}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	series.ID = primitive.NewObjectID()
+	_, err := pg.db.ExecContext(ctx, `INSERT INTO reservation_series (id, charger_id, user_id, rrule, starts_at, ends_at, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		series.ID.Hex(), series.ChargerID.Hex(), series.UserID.Hex(), series.RRule, series.From, series.To, series.Created)
+	if err != nil {
+		pg.logger.Log("Error inserting reservation series into DB: ", err.Error())
+		return series, results, err
+	}
+	for _, occurrence := range occurrences {
+		reservation := newReservation(series.UserID, series.ChargerID, occurrence.From, occurrence.To, series.Created)
+		reservation.SeriesID = series.ID
+		result := OccurrenceResult{From: occurrence.From, To: occurrence.To, Status: OccurrenceBooked}
+		id, err := pg.insert(ctx, reservation)
+		switch {
+		case errors.Is(err, ErrReservationConflict):
+			result.Status = OccurrenceConflict
+			result.Error = err.Error()
+		case err != nil:
+			pg.logger.Log("Error inserting reservation into DB: ", err.Error())
+			return series, results, err
+		default:
+			result.ReservationID = id.Hex()
+		}
+		results = append(results, result)
+	}
+	return series, results, nil
+}
+
+func (pg *postgresDatabase) MoveSeries(ctx context.Context, id string, shift time.Duration, length time.Duration) error {
+	if !primitive.IsValidObjectID(id) {
+		return invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := pg.db.ExecContext(ctx, `UPDATE reservation_series
+		SET starts_at = starts_at + $2 * interval '1 microsecond',
+			ends_at = starts_at + ($2 + $3) * interval '1 microsecond'
+		WHERE id = $1`,
+		id, shift.Microseconds(), length.Microseconds())
+	if err != nil {
+		pg.logger.Log("Error moving reservation series: ", err.Error())
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSeriesNotFound
+	}
+	return nil
+}
+
+func (pg *postgresDatabase) SplitSeries(ctx context.Context, id string, at time.Time, shift time.Duration, length time.Duration, occurrences []string) (ReservationSeries, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ReservationSeries{}, invalidID("id", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ReservationSeries{}, err
+	}
+	defer tx.Rollback()
+	series := ReservationSeries{ID: objectID}
+	var chargerID, userID string
+	err = tx.QueryRowContext(ctx, `SELECT charger_id, user_id, rrule, starts_at, ends_at, created
+		FROM reservation_series WHERE id = $1 FOR UPDATE`, id).
+		Scan(&chargerID, &userID, &series.RRule, &series.From, &series.To, &series.Created)
+	if err == sql.ErrNoRows {
+		return series, ErrSeriesNotFound
+	}
+	if err != nil {
+		pg.logger.Log("Error splitting reservation series: ", err.Error())
+		return series, err
+	}
+	series.ChargerID, _ = primitive.ObjectIDFromHex(chargerID)
+	series.UserID, _ = primitive.ObjectIDFromHex(userID)
+	ended, following, err := splitSeries(series, at, shift, length)
+	if err != nil {
+		return following, err
+	}
+	following.ID = primitive.NewObjectID()
+	_, err = tx.ExecContext(ctx, `INSERT INTO reservation_series (id, charger_id, user_id, rrule, starts_at, ends_at, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		following.ID.Hex(), following.ChargerID.Hex(), following.UserID.Hex(), following.RRule, following.From, following.To, following.Created)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE reservation_series SET rrule = $2 WHERE id = $1`, id, ended.RRule)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE reservations
+			SET series_id = $2, modified = $3, version = version + 1
+			WHERE id = ANY($4) AND series_id = $1`,
+			id, following.ID.Hex(), following.Created, pq.Array(occurrences))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		pg.logger.Log("Error splitting reservation series: ", err.Error())
+		return following, err
+	}
+	return following, nil
+}
+
+func (pg *postgresDatabase) ReservationClosest(ctx context.Context, userID string, from time.Time, to time.Time, location Location) (Reservation, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Reservation{}, invalidID("userID", userID)
+	}
+	insertCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	reservation, err := bookClosest(ctx, pg.chargers, location, pg.maxSearchRadius, newReservation(userObjectID, primitive.NilObjectID, from, to, time.Now().UTC()), func(r Reservation) (primitive.ObjectID, error) {
+		return pg.insert(insertCtx, r)
+	})
+	if err != nil && !errors.Is(err, ErrNoChargerAvailable) {
+		pg.logger.Log("Error booking the closest charger: ", err.Error())
+	}
+	return reservation, err
+}
+
+func (pg *postgresDatabase) FindChargers(ctx context.Context, from time.Time, to time.Time, location Location) ([]ChargerCandidate, error) {
+	nearby, err := pg.chargers.WithinRadius(ctx, location, pg.maxSearchRadius)
+	if err != nil {
+		pg.logger.Log("Error getting chargers: ", err.Error())
+		return nil, err
+	}
+	ids := []string{}
+	for _, candidate := range nearby {
+		ids = append(ids, candidate.Charger.ID.Hex())
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rows, err := pg.db.QueryContext(ctx, `SELECT DISTINCT charger_id FROM reservations
+		WHERE charger_id = ANY($1) AND state <> ALL($2) AND starts_at < $3 AND ends_at > $4`,
+		pq.Array(ids), pq.Array(stateStrings(releasedStates)), to, from)
+	if err != nil {
+		pg.logger.Log("Error getting reservations from DB: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	busy := map[primitive.ObjectID]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		chargerID, _ := primitive.ObjectIDFromHex(id)
+		busy[chargerID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return chargerCandidates(nearby, busy), nil
+}
+
+// insert stores reservation under a new id. The exclusion constraint rejects
+// it if it overlaps an active booking on the same charger.
+func (pg *postgresDatabase) insert(ctx context.Context, reservation Reservation) (primitive.ObjectID, error) {
+	id := primitive.NewObjectID()
+	history, err := json.Marshal(reservation.History)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var seriesID interface{}
+	if !reservation.SeriesID.IsZero() {
+		seriesID = reservation.SeriesID.Hex()
+	}
+	_, err = pg.db.ExecContext(ctx, `INSERT INTO reservations (`+reservationColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		id.Hex(), reservation.ChargerID.Hex(), reservation.UserID.Hex(), seriesID,
+		reservation.From, reservation.To, string(reservation.State), string(history),
+		reservation.Version, reservation.Created, reservation.Modified)
+	if err != nil {
+		return primitive.NilObjectID, postgresError(err)
+	}
+	return id, nil
+}
+
+func (pg *postgresDatabase) query(ctx context.Context, query string, args ...interface{}) ([]Reservation, error) {
+	reservations := []Reservation{}
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		pg.logger.Log("Error getting reservations from DB: ", err.Error())
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		reservation, err := scanReservation(rows)
+		if err != nil {
+			pg.logger.Log("Error getting reservations from DB: ", err.Error())
+			return reservations, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	return reservations, rows.Err()
+}
+
+// missedVersion explains why a versioned write matched nothing: either the
+// reservation is gone or its version has moved on.
+func (pg *postgresDatabase) missedVersion(ctx context.Context, id string) error {
+	exists := false
+	err := pg.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM reservations WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrReservationNotFound
+	}
+	return ErrVersionMismatch
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanReservation(row rowScanner) (Reservation, error) {
+	reservation := Reservation{}
+	var id, chargerID, userID, state string
+	var seriesID sql.NullString
+	var history []byte
+	err := row.Scan(&id, &chargerID, &userID, &seriesID, &reservation.From, &reservation.To,
+		&state, &history, &reservation.Version, &reservation.Created, &reservation.Modified)
+	if err != nil {
+		return Reservation{}, err
+	}
+	reservation.ID, _ = primitive.ObjectIDFromHex(id)
+	reservation.ChargerID, _ = primitive.ObjectIDFromHex(chargerID)
+	reservation.UserID, _ = primitive.ObjectIDFromHex(userID)
+	if seriesID.Valid {
+		reservation.SeriesID, _ = primitive.ObjectIDFromHex(seriesID.String)
+	}
+	reservation.State = ReservationState(state)
+	if err := json.Unmarshal(history, &reservation.History); err != nil {
+		return Reservation{}, err
+	}
+	reservation.From = reservation.From.UTC()
+	reservation.To = reservation.To.UTC()
+	reservation.Created = reservation.Created.UTC()
+	reservation.Modified = reservation.Modified.UTC()
+	return reservation, nil
+}
+
+// postgresFilter translates a ReservationFilter into a WHERE clause and its
+// arguments.
+func postgresFilter(filter ReservationFilter) (string, []interface{}, error) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.ChargerID != "" {
+		if !primitive.IsValidObjectID(filter.ChargerID) {
+			return "", nil, invalidID("charger", filter.ChargerID)
+		}
+		conditions = append(conditions, "charger_id = "+arg(filter.ChargerID))
+	}
+	if len(filter.ChargerIDs) > 0 {
+		for _, id := range filter.ChargerIDs {
+			if !primitive.IsValidObjectID(id) {
+				return "", nil, invalidID("charger", id)
+			}
+		}
+		conditions = append(conditions, "charger_id = ANY("+arg(pq.Array(filter.ChargerIDs))+")")
+	}
+	if filter.UserID != "" {
+		if !primitive.IsValidObjectID(filter.UserID) {
+			return "", nil, invalidID("user", filter.UserID)
+		}
+		conditions = append(conditions, "user_id = "+arg(filter.UserID))
+	}
+	if filter.SeriesID != "" {
+		if !primitive.IsValidObjectID(filter.SeriesID) {
+			return "", nil, invalidID("series", filter.SeriesID)
+		}
+		conditions = append(conditions, "series_id = "+arg(filter.SeriesID))
+	}
+	if len(filter.States) > 0 {
+		conditions = append(conditions, "state = ANY("+arg(pq.Array(stateStrings(filter.States)))+")")
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "starts_at < "+arg(filter.To))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "ends_at > "+arg(filter.From))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created >= "+arg(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conditions = append(conditions, "created < "+arg(filter.CreatedTo))
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func stateStrings(states []ReservationState) []string {
+	result := make([]string, len(states))
+	for i, state := range states {
+		result[i] = string(state)
+	}
+	return result
+}
+
+// postgresError turns a violation of the overlap constraint into
+// ErrReservationConflict.
+func postgresError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23P01" {
+		return ErrReservationConflict
+	}
+	return err
+}
+
+type postgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+// NewPostgresIdempotencyStore keeps idempotency records in the
+// idempotency_keys table created by MigratePostgres.
+func NewPostgresIdempotencyStore(db *sql.DB) IdempotencyStore {
+	return &postgresIdempotencyStore{db: db}
+}
+
+func (st *postgresIdempotencyStore) Reserve(ctx context.Context, userID string, key string, fingerprint string, expires time.Time) (IdempotencyRecord, bool, error) {
+	record := IdempotencyRecord{
+		ID:          idempotencyID(userID, key),
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Expires:     expires,
+	}
+	// Postgres has no TTL index, so expired records are dropped here.
+	if _, err := st.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires < now()`); err != nil {
+		return record, false, err
+	}
+	res, err := st.db.ExecContext(ctx, `INSERT INTO idempotency_keys (id, user_id, key, fingerprint, expires)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING`,
+		record.ID, userID, key, fingerprint, expires)
+	if err != nil {
+		return record, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return record, true, nil
+	}
+	existing := IdempotencyRecord{ID: record.ID}
+	err = st.db.QueryRowContext(ctx, `SELECT user_id, key, fingerprint, completed, response, expires
+		FROM idempotency_keys WHERE id = $1`, record.ID).
+		Scan(&existing.UserID, &existing.Key, &existing.Fingerprint, &existing.Completed, &existing.Response, &existing.Expires)
+	if err == sql.ErrNoRows {
+		// Released between the insert and the lookup; the retry will claim it.
+		return record, false, ErrIdempotencyInProgress
+	}
+	if err != nil {
+		return record, false, err
+	}
+	return existing, false, nil
+}
+
+func (st *postgresIdempotencyStore) Complete(ctx context.Context, userID string, key string, response []byte) error {
+	_, err := st.db.ExecContext(ctx, `UPDATE idempotency_keys SET completed = true, response = $2 WHERE id = $1`,
+		idempotencyID(userID, key), response)
+	return err
+}
+
+func (st *postgresIdempotencyStore) Release(ctx context.Context, userID string, key string) error {
+	_, err := st.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE id = $1 AND NOT completed`, idempotencyID(userID, key))
+	return err
+}
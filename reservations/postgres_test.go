@@ -0,0 +1,75 @@
+package reservations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// TestPostgresDatabase runs the conformance suite against the PostgreSQL
+// database in RESERVATIONS_TEST_POSTGRES_DSN. The tables are emptied before
+// every subtest.
+func TestPostgresDatabase(t *testing.T) {
+	dsn := os.Getenv("RESERVATIONS_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("RESERVATIONS_TEST_POSTGRES_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := MigratePostgres(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	// A second run must find everything applied.
+	if err := MigratePostgres(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	testReservationDB(t, func(t *testing.T, chargers *ChargerIndex) ReservationDB {
+		if _, err := db.Exec(`TRUNCATE reservations, reservation_series`); err != nil {
+			t.Fatal(err)
+		}
+		return NewPostgresDatabase(db, log.NewNopLogger(), chargers, conformanceRadius)
+	})
+}
+
+func TestPostgresMigrationsEmbedded(t *testing.T) {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 || names[0] != "migrations/postgres/0001_create_reservations.sql" {
+		t.Errorf("got migrations %v", names)
+	}
+}
+
+func TestPostgresFilter(t *testing.T) {
+	since := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	where, args, err := postgresFilter(ReservationFilter{
+		UserID: "5f8f8c44b54764421b7156c1",
+		States: []ReservationState{StatePending, StateConfirmed},
+		From:   since,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TRUE AND user_id = $1 AND state = ANY($2) AND ends_at > $3"; where != want {
+		t.Errorf("got %q, want %q", where, want)
+	}
+	if len(args) != 3 || args[2] != since {
+		t.Errorf("got args %v", args)
+	}
+	if _, _, err := postgresFilter(ReservationFilter{SeriesID: "nope"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("malformed series id: %v", err)
+	}
+}
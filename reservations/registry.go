@@ -0,0 +1,159 @@
+package reservations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Agent is the part of the Consul agent API that Registrar uses.
+// *consulapi.Agent satisfies it.
+type Agent interface {
+	ServiceRegister(service *consulapi.AgentServiceRegistration) error
+	ServiceDeregister(serviceID string) error
+	UpdateTTL(checkID string, output string, status string) error
+}
+
+// Registration describes how the service appears in the Consul catalog.
+type Registration struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	// HealthURL is polled by the agent every HealthInterval.
+	HealthURL      string
+	HealthInterval time.Duration
+	// TTL is how long the agent waits for a heartbeat before marking the
+	// instance critical.
+	TTL time.Duration
+	// DeregisterAfter removes an instance that stays critical this long,
+	// e.g. because it was killed without deregistering.
+	DeregisterAfter time.Duration
+}
+
+// Registrar registers the service in Consul and keeps its TTL check alive
+// while the health checks pass.
+type Registrar struct {
+	agent        Agent
+	registration Registration
+	health       *Health
+	logger       log.Logger
+
+	// stop and stopped are set by Start so Deregister can end the
+	// heartbeat before leaving the catalog.
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewRegistrar creates a Registrar that reports health's result on the TTL
+// check.
+func NewRegistrar(agent Agent, registration Registration, health *Health, logger log.Logger) *Registrar {
+	return &Registrar{
+		agent:        agent,
+		registration: registration,
+		health:       health,
+		logger:       log.With(logger, "component", "registrar"),
+	}
+}
+
+func (r *Registrar) ttlCheckID() string {
+	return "service:" + r.registration.ID + ":ttl"
+}
+
+// Register adds the service and its checks to the local agent.
+func (r *Registrar) Register() error {
+	reg := r.registration
+	service := &consulapi.AgentServiceRegistration{
+		ID:      reg.ID,
+		Name:    reg.Name,
+		Address: reg.Address,
+		Port:    reg.Port,
+		Tags:    reg.Tags,
+		Checks: consulapi.AgentServiceChecks{
+			{
+				CheckID:                        "service:" + reg.ID + ":http",
+				Name:                           "HTTP health",
+				HTTP:                           reg.HealthURL,
+				Method:                         "GET",
+				Interval:                       reg.HealthInterval.String(),
+				Timeout:                        healthTimeout.String(),
+				DeregisterCriticalServiceAfter: reg.DeregisterAfter.String(),
+			},
+			{
+				CheckID:                        r.ttlCheckID(),
+				Name:                           "Heartbeat",
+				TTL:                            reg.TTL.String(),
+				DeregisterCriticalServiceAfter: reg.DeregisterAfter.String(),
+			},
+		},
+	}
+	if err := r.agent.ServiceRegister(service); err != nil {
+		return fmt.Errorf("registering %s in consul: %w", reg.ID, err)
+	}
+	r.logger.Log("msg", "registered in consul", "id", reg.ID, "address", reg.Address, "port", reg.Port)
+	return nil
+}
+
+// Start runs the heartbeat in the background until ctx is done or
+// Deregister is called.
+func (r *Registrar) Start(ctx context.Context) {
+	ctx, r.stop = context.WithCancel(ctx)
+	r.stopped = make(chan struct{})
+	go func() {
+		defer close(r.stopped)
+		r.Run(ctx)
+	}()
+}
+
+// Run updates the TTL check a few times per TTL until ctx is done. An
+// instance whose checks fail is reported critical, so it stops receiving
+// traffic without being removed.
+func (r *Registrar) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.registration.TTL / 3)
+	defer ticker.Stop()
+	for {
+		r.heartbeat(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registrar) heartbeat(ctx context.Context) {
+	report := r.health.Check(ctx)
+	status := consulapi.HealthPassing
+	output := "all checks passed"
+	if report.Status != "pass" {
+		status = consulapi.HealthCritical
+		output = fmt.Sprint(report.Checks)
+	}
+	if err := r.agent.UpdateTTL(r.ttlCheckID(), output, status); err != nil {
+		// The agent forgets its services when it restarts without state.
+		level.Warn(r.logger).Log("msg", "updating consul ttl check, registering again", "err", err)
+		if err := r.Register(); err != nil {
+			level.Error(r.logger).Log("err", err)
+		}
+	}
+}
+
+// Deregister stops the heartbeat started by Start and then removes the
+// service and its checks from the local agent. The heartbeat has to be gone
+// first, or its next failed TTL update would register the service again.
+func (r *Registrar) Deregister() error {
+	if r.stop != nil {
+		r.stop()
+		<-r.stopped
+	}
+	if err := r.agent.ServiceDeregister(r.registration.ID); err != nil {
+		return fmt.Errorf("deregistering %s from consul: %w", r.registration.ID, err)
+	}
+	r.logger.Log("msg", "deregistered from consul", "id", r.registration.ID)
+	return nil
+}
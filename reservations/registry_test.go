@@ -0,0 +1,180 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+type fakeAgent struct {
+	mu         sync.Mutex
+	services   map[string]*consulapi.AgentServiceRegistration
+	ttl        map[string]string
+	ttlErr     error
+	registered int
+	// lateRegistered counts registrations after the service was
+	// deregistered.
+	deregistered   bool
+	lateRegistered int
+}
+
+func newFakeAgent() *fakeAgent {
+	return &fakeAgent{services: map[string]*consulapi.AgentServiceRegistration{}, ttl: map[string]string{}}
+}
+
+func (a *fakeAgent) ServiceRegister(service *consulapi.AgentServiceRegistration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.services[service.ID] = service
+	a.registered++
+	if a.deregistered {
+		a.lateRegistered++
+	}
+	return nil
+}
+
+func (a *fakeAgent) ServiceDeregister(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.services, id)
+	a.deregistered = true
+	return nil
+}
+
+func (a *fakeAgent) UpdateTTL(checkID string, output string, status string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ttlErr != nil {
+		return a.ttlErr
+	}
+	a.ttl[checkID] = status
+	return nil
+}
+
+func (a *fakeAgent) status(checkID string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ttl[checkID]
+}
+
+func TestRegistrar(t *testing.T) {
+	agent := newFakeAgent()
+	health := NewHealth()
+	healthy := make(chan error, 1)
+	healthy <- nil
+	health.Add("db", func(ctx context.Context) error {
+		err := <-healthy
+		healthy <- err
+		return err
+	})
+	registrar := NewRegistrar(agent, Registration{
+		ID:              "reservations-1",
+		Name:            "reservations",
+		Address:         "10.0.0.7",
+		Port:            8080,
+		Tags:            []string{"v1"},
+		HealthURL:       "http://10.0.0.7:8080/health",
+		HealthInterval:  10 * time.Second,
+		TTL:             30 * time.Millisecond,
+		DeregisterAfter: time.Minute,
+	}, health, log.NewNopLogger())
+
+	if err := registrar.Register(); err != nil {
+		t.Fatal(err)
+	}
+	service := agent.services["reservations-1"]
+	if service == nil || service.Port != 8080 || len(service.Checks) != 2 {
+		t.Fatalf("got registration %+v", service)
+	}
+	if check := service.Checks[0]; check.HTTP != "http://10.0.0.7:8080/health" || check.Interval != "10s" {
+		t.Errorf("got http check %+v", check)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registrar.Run(ctx)
+		close(done)
+	}()
+	checkID := "service:reservations-1:ttl"
+	waitFor(t, "passing ttl", func() bool { return agent.status(checkID) == consulapi.HealthPassing })
+	<-healthy
+	healthy <- errors.New("connection refused")
+	waitFor(t, "critical ttl", func() bool { return agent.status(checkID) == consulapi.HealthCritical })
+
+	agent.mu.Lock()
+	agent.ttlErr = errors.New("unknown check")
+	agent.mu.Unlock()
+	waitFor(t, "registering again", func() bool {
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		return agent.registered > 1
+	})
+	cancel()
+	<-done
+
+	if err := registrar.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	if len(agent.services) != 0 {
+		t.Errorf("still registered: %v", agent.services)
+	}
+}
+
+func TestRegistrarDeregisterStopsHeartbeat(t *testing.T) {
+	agent := newFakeAgent()
+	// Every heartbeat fails and registers the service again.
+	agent.ttlErr = errors.New("unknown check")
+	registrar := NewRegistrar(agent, Registration{
+		ID:   "reservations-1",
+		Name: "reservations",
+		TTL:  3 * time.Millisecond,
+	}, NewHealth(), log.NewNopLogger())
+
+	registrar.Start(context.Background())
+	waitFor(t, "registering again", func() bool {
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		return agent.registered > 1
+	})
+	if err := registrar.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if agent.lateRegistered != 0 || len(agent.services) != 0 {
+		t.Errorf("registered %d times after deregistering, services %v", agent.lateRegistered, agent.services)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	health := NewHealth()
+	health.Add("db", func(ctx context.Context) error { return nil })
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d", rec.Code)
+	}
+
+	health.Add("chargers", func(ctx context.Context) error { return errors.New("unreachable") })
+	rec = httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	report := HealthReport{}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || report.Status != "fail" ||
+		report.Checks["db"] != "ok" || report.Checks["chargers"] != "unreachable" {
+		t.Errorf("got %d %+v", rec.Code, report)
+	}
+}
@@ -3,17 +3,23 @@ package reservations
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	ht "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 )
 
 type (
 	CreateReservationRequest struct {
-		ChargerID string `json:"chargerID"`
-		UserToken string `json:"userToken"`
-		From      string `json:"from"`
-		To        string `json:"to"`
+		ChargerID string    `json:"chargerID"`
+		From      time.Time `json:"from"`
+		To        time.Time `json:"to"`
 	}
 	CreateReservationResponse struct {
 		Status string `json:"status"`
@@ -22,52 +28,121 @@ type (
 		Id string `json:"id"`
 	}
 	GetReservationResponse struct {
-		ChargerID string `json:"chargerID"`
-		UserID    string `json:"userID"`
-		From      string `json:"from"`
-		To        string `json:"to"`
-		Created   string `json:"created"`
-		Modified  string `json:"modified"`
+		ChargerID string            `json:"chargerID"`
+		UserID    string            `json:"userID"`
+		SeriesID  string            `json:"seriesID,omitempty"`
+		From      time.Time         `json:"from"`
+		To        time.Time         `json:"to"`
+		State     ReservationState  `json:"state"`
+		History   []StateTransition `json:"history"`
+		Version   int64             `json:"version"`
+		Created   time.Time         `json:"created"`
+		Modified  time.Time         `json:"modified"`
 	}
 	GetReservationsRequest struct {
+		States []ReservationState `json:"states"`
+		Page   PageRequest        `json:"-"`
 	}
 	GetReservationsResponse struct {
 		Reservations []Reservation `json:"reservations"`
+		NextCursor   string        `json:"nextCursor,omitempty"`
 	}
 	UpdateReservationRequest struct {
-		Id   string `json:"id"`
-		From string `json:"from"`
-		To   string `json:"to"`
+		Id               string    `json:"id"`
+		From             time.Time `json:"from"`
+		To               time.Time `json:"to"`
+		ExpectedVersions []int64   `json:"-"`
 	}
 	UpdateReservationResponse struct {
 		Status string `json:"status"`
 	}
 	DeleteReservationRequest struct {
-		Id string `json:"id"`
+		Id               string  `json:"id"`
+		ExpectedVersions []int64 `json:"-"`
 	}
 	DeleteReservationResponse struct {
 		Status string `json:"status"`
 	}
 	GetReservationsFilterRequest struct {
-		ChargerID string `json:"chargerID"`
-		UserID    string `json:"userID"`
+		ChargerID   string             `json:"chargerID"`
+		ChargerIDs  []string           `json:"chargerIDs"`
+		UserID      string             `json:"userID"`
+		States      []ReservationState `json:"states"`
+		From        time.Time          `json:"from"`
+		To          time.Time          `json:"to"`
+		CreatedFrom time.Time          `json:"createdFrom"`
+		CreatedTo   time.Time          `json:"createdTo"`
+		Page        PageRequest        `json:"-"`
 	}
 	GetReservationsFilterResponse struct {
 		Reservations []Reservation `json:"reservations"`
+		NextCursor   string        `json:"nextCursor,omitempty"`
 	}
 	ReservationClosestRequest struct {
-		UserToken string   `json:"userToken"`
-		From      string   `json:"from"`
-		To        string   `json:"to"`
-		Location  Location `json:"location"`
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+		Location *Location `json:"location"`
 	}
 	ReservationClosestResponse struct {
-		ChargerID string `json:"chargerID"`
-		UserID    string `json:"userID"`
-		From      string `json:"from"`
-		To        string `json:"to"`
-		Created   string `json:"created"`
-		Modified  string `json:"modified"`
+		Status    string    `json:"status"`
+		ID        string    `json:"id"`
+		ChargerID string    `json:"chargerID"`
+		UserID    string    `json:"userID"`
+		From      time.Time `json:"from"`
+		To        time.Time `json:"to"`
+		Created   time.Time `json:"created"`
+		Modified  time.Time `json:"modified"`
+	}
+	TransitionReservationRequest struct {
+		Id string `json:"id"`
+	}
+	TransitionReservationResponse struct {
+		Status  string            `json:"status"`
+		State   ReservationState  `json:"state"`
+		History []StateTransition `json:"history"`
+	}
+	GetAvailabilityRequest struct {
+		ChargerID string        `json:"chargerID"`
+		From      time.Time     `json:"from"`
+		To        time.Time     `json:"to"`
+		Slot      time.Duration `json:"slot"`
+	}
+	GetAvailabilityResponse struct {
+		Availability Availability `json:"availability"`
+	}
+	CreateRecurringRequest struct {
+		ChargerID string    `json:"chargerID"`
+		From      time.Time `json:"from"`
+		To        time.Time `json:"to"`
+		RRule     string    `json:"rrule"`
+	}
+	CreateRecurringResponse struct {
+		Status      string             `json:"status"`
+		SeriesID    string             `json:"seriesID"`
+		Occurrences []OccurrenceResult `json:"occurrences"`
+	}
+	UpdateSeriesRequest struct {
+		Id    string      `json:"id"`
+		From  time.Time   `json:"from"`
+		To    time.Time   `json:"to"`
+		Scope SeriesScope `json:"scope"`
+	}
+	CancelSeriesRequest struct {
+		Id    string      `json:"id"`
+		Scope SeriesScope `json:"scope"`
+	}
+	SeriesResponse struct {
+		Status      string             `json:"status"`
+		Occurrences []OccurrenceResult `json:"occurrences"`
+	}
+	SearchChargersRequest struct {
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+		Location *Location `json:"location"`
+		Limit    int       `json:"limit"`
+	}
+	SearchChargersResponse struct {
+		Candidates []ChargerCandidate `json:"candidates"`
 	}
 	//OTHER
 	GetChargerRatingsRequest struct {
@@ -87,14 +162,120 @@ type (
 	}
 )
 
+// Headers exposes the reservation version as an ETag.
+func (r GetReservationResponse) Headers() http.Header {
+	return http.Header{"ETag": []string{formatETag(r.Version)}}
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if headerer, ok := response.(ht.Headerer); ok {
+		for k, values := range headerer.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
 	return json.NewEncoder(w).Encode(response)
 }
 
+// Problem is the RFC 7807 body returned for every failed request. Code is a
+// stable, machine-readable name for the error kind.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	// Violations lists the offending fields of an invalid request.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// errorStatus maps err to an HTTP status and problem code. More specific
+// errors are checked before the kinds they belong to.
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrVersionMismatch):
+		return http.StatusPreconditionFailed, "precondition_failed"
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity, "idempotency_key_reused"
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest, "invalid_argument"
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict, "conflict"
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, "unauthorized"
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return http.StatusServiceUnavailable, "upstream_unavailable"
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	code, name := errorStatus(err)
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: err.Error(),
+		Code:   name,
+	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		problem.Detail = "request has invalid fields"
+		problem.Violations = invalid.Violations
+	}
+	if code == http.StatusInternalServerError {
+		// Unclassified errors come from the driver or the runtime and
+		// are not meant for clients.
+		problem.Detail = ""
+	}
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch turns an If-Match header into the reservation versions it
+// lists. A missing header or "*" matches any version and gives nil. If-Match
+// compares entity tags strongly, so weak tags are left out and a header of
+// only weak tags matches no version.
+func parseIfMatch(header string) ([]int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	versions := []int64{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		weak := strings.HasPrefix(tag, "W/")
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, ErrInvalidETag
+		}
+		version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil || version < 0 {
+			return nil, ErrInvalidETag
+		}
+		if !weak {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
 func decodeCreateReservationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	req := CreateReservationRequest{}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	req.UserToken = r.Header.Get("Authorization")
+	err := decodeJSON(r, &req)
 	if err != nil {
 		return nil, err
 	}
@@ -102,12 +283,16 @@ func decodeCreateReservationRequest(ctx context.Context, r *http.Request) (inter
 }
 func decodeUpdateReservationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	req := UpdateReservationRequest{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeJSON(r, &req)
 	if err != nil {
 		return nil, err
 	}
 	vals := mux.Vars(r)
 	req.Id = vals["id"]
+	req.ExpectedVersions, err = parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		return nil, fieldError("If-Match", err)
+	}
 	return req, nil
 }
 func decodeGetReservationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -118,25 +303,178 @@ func decodeGetReservationRequest(ctx context.Context, r *http.Request) (interfac
 	return req, nil
 }
 func decodeGetReservationsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	req := GetReservationRequest{}
+	req := GetReservationsRequest{}
+	query := r.URL.Query()
+	v := violations{}
+	states, err := parseStates(query.Get("state"))
+	if err != nil {
+		v.add("state", "%v", err)
+	}
+	req.States = states
+	req.Page = parsePageRequest(query, &v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
 	return req, nil
 }
+
+// parsePageRequest reads the sort, limit and cursor parameters of a listing.
+func parsePageRequest(query url.Values, v *violations) PageRequest {
+	page := PageRequest{Cursor: query.Get("cursor")}
+	var err error
+	if page.Sort, page.Descending, err = ParseSort(query.Get("sort")); err != nil {
+		v.add("sort", "must be one of from, -from, created, -created")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil {
+			v.add("limit", "must be a number")
+		} else if page.Limit < 1 {
+			// A zero Limit stands for a left out limit, so it cannot be
+			// asked for.
+			v.add("limit", "must be between 1 and %d", MaxPageSize)
+		}
+	}
+	return page
+}
+
+// parseQueryTime reads an optional RFC3339 query parameter.
+func parseQueryTime(query url.Values, name string, v *violations) time.Time {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.add(name, "must be an RFC 3339 timestamp")
+	}
+	return t
+}
 func decodeDeleteReservationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	req := DeleteReservationRequest{}
 	vals := mux.Vars(r)
 	req.Id = vals["id"]
+	var err error
+	req.ExpectedVersions, err = parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		return nil, fieldError("If-Match", err)
+	}
 	return req, nil
 }
 func decodeGetReservationsFilterRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	req := GetReservationsFilterRequest{}
-	req.ChargerID = r.URL.Query().Get("charger")
-	req.UserID = r.URL.Query().Get("user")
+	query := r.URL.Query()
+	v := violations{}
+	// charger may be repeated or hold a comma separated list.
+	chargers := []string{}
+	for _, value := range query["charger"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				chargers = append(chargers, id)
+			}
+		}
+	}
+	if len(chargers) == 1 {
+		req.ChargerID = chargers[0]
+	} else if len(chargers) > 1 {
+		req.ChargerIDs = chargers
+	}
+	req.UserID = query.Get("user")
+	states, err := parseStates(query.Get("state"))
+	if err != nil {
+		v.add("state", "%v", err)
+	}
+	req.States = states
+	req.From = parseQueryTime(query, "from", &v)
+	req.To = parseQueryTime(query, "to", &v)
+	req.CreatedFrom = parseQueryTime(query, "createdFrom", &v)
+	req.CreatedTo = parseQueryTime(query, "createdTo", &v)
+	req.Page = parsePageRequest(query, &v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
 	return req, nil
 }
 func decodeReservationClosestRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	req := ReservationClosestRequest{}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	req.UserToken = r.Header.Get("Authorization")
+	err := decodeJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+func decodeTransitionReservationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := TransitionReservationRequest{}
+	vals := mux.Vars(r)
+	req.Id = vals["id"]
+	return req, nil
+}
+func decodeGetAvailabilityRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := GetAvailabilityRequest{}
+	vals := mux.Vars(r)
+	req.ChargerID = vals["id"]
+	query := r.URL.Query()
+	var err error
+	v := violations{}
+	if req.From, err = time.Parse(time.RFC3339, query.Get("from")); err != nil {
+		v.add("from", "must be an RFC 3339 timestamp")
+	}
+	if req.To, err = time.Parse(time.RFC3339, query.Get("to")); err != nil {
+		v.add("to", "must be an RFC 3339 timestamp")
+	}
+	if slot := query.Get("slot"); slot != "" {
+		if req.Slot, err = time.ParseDuration(slot); err != nil {
+			v.add("slot", "must be a duration such as 30m")
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+func decodeCreateRecurringRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := CreateRecurringRequest{}
+	err := decodeJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+func decodeUpdateSeriesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := UpdateSeriesRequest{}
+	err := decodeJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	vals := mux.Vars(r)
+	req.Id = vals["id"]
+	// The scope may come from the body or the query, but not differ.
+	query := r.URL.Query().Get("scope")
+	if query != "" && req.Scope != "" && SeriesScope(query) != req.Scope {
+		return nil, fieldError("scope", fmt.Errorf("query %q contradicts body %q", query, req.Scope))
+	}
+	if query == "" {
+		query = string(req.Scope)
+	}
+	req.Scope, err = ParseSeriesScope(query)
+	if err != nil {
+		return nil, fieldError("scope", err)
+	}
+	return req, nil
+}
+func decodeCancelSeriesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := CancelSeriesRequest{}
+	vals := mux.Vars(r)
+	req.Id = vals["id"]
+	var err error
+	req.Scope, err = ParseSeriesScope(r.URL.Query().Get("scope"))
+	if err != nil {
+		return nil, fieldError("scope", err)
+	}
+	return req, nil
+}
+func decodeSearchChargersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := SearchChargersRequest{}
+	err := decodeJSON(r, &req)
 	if err != nil {
 		return nil, err
 	}
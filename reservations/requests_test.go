@@ -0,0 +1,95 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		header   string
+		versions []int64
+	}{
+		{"", nil},
+		{"*", nil},
+		{` "3" `, []int64{3}},
+		{formatETag(12), []int64{12}},
+		{`"2", "3"`, []int64{2, 3}},
+		{`W/"3"`, []int64{}},
+		{`W/"2", "3"`, []int64{3}},
+	}
+	for _, c := range cases {
+		versions, err := parseIfMatch(c.header)
+		if err != nil || !reflect.DeepEqual(versions, c.versions) {
+			t.Errorf("%q: got %v, %v, want %v", c.header, versions, err, c.versions)
+		}
+	}
+	for _, header := range []string{`"abc"`, `"-2"`, `3`, `"1",`, `W/`} {
+		if _, err := parseIfMatch(header); !errors.Is(err, ErrInvalidETag) {
+			t.Errorf("%q: got %v, want ErrInvalidETag", header, err)
+		}
+	}
+}
+
+func TestReservationETagHTTP(t *testing.T) {
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	user := primitive.NewObjectID()
+	asUser := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal := Principal{UserID: user.Hex(), Roles: []Role{RoleUser}}
+			if _, ok := request.(DeleteReservationRequest); ok {
+				principal = Principal{UserID: primitive.NewObjectID().Hex(), Roles: []Role{RoleAdmin}}
+			}
+			return next(NewContextWithPrincipal(ctx, principal), request)
+		}
+	}
+	handler := NewHttpServer(context.Background(), MakeEndpoints(srv).Wrap(asUser), NewHealth())
+	reservation := mustCreate(t, db, user, primitive.NewObjectID(), hour(0), hour(1))
+	path := "/reservations/" + reservation.ID.Hex()
+	do := func(method string, ifMatch string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	move := `{"from":"` + hour(2).Format(time.RFC3339) + `","to":"` + hour(3).Format(time.RFC3339) + `"}`
+
+	if w := do(http.MethodGet, "", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET: got %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := do(http.MethodPut, "not-a-version", move); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with a malformed If-Match: got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPut, `W/"1"`, move); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a weak ETag: got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPut, `"7", "1"`, move); w.Code != http.StatusOK {
+		t.Fatalf("PUT with the current version in a list: got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "", ""); w.Header().Get("ETag") != `"2"` {
+		t.Errorf("GET after PUT: ETag %q", w.Header().Get("ETag"))
+	}
+	if w := do(http.MethodPut, `"1"`, move); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale version: got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, `"1"`, ""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale version: got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "*", ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE with *: got %d %s", w.Code, w.Body)
+	}
+}
@@ -2,7 +2,9 @@ package reservations
 
 import (
 	"context"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -44,18 +46,134 @@ type Reservation struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	ChargerID primitive.ObjectID `json:"chargerID"`
 	UserID    primitive.ObjectID `json:"userID"`
-	From      string             `json:"from"`
-	To        string             `json:"to"`
-	Created   string             `json:"created"`
-	Modified  string             `json:"modified"`
+	From      time.Time          `json:"from"`
+	To        time.Time          `json:"to"`
+	SeriesID  primitive.ObjectID `json:"seriesID,omitempty" bson:"seriesid,omitempty"`
+	State     ReservationState   `json:"state"`
+	History   []StateTransition  `json:"history"`
+	Version   int64              `json:"version"`
+	Created   time.Time          `json:"created"`
+	Modified  time.Time          `json:"modified"`
+}
+
+// AnyVersion disables the optimistic concurrency check on writes.
+const AnyVersion int64 = -1
+
+// expectedVersion picks which version a write must find, given the current
+// version of the reservation and the versions the client accepts. nil
+// accepts any version.
+func expectedVersion(current int64, accepted []int64) (int64, error) {
+	if accepted == nil {
+		return AnyVersion, nil
+	}
+	for _, version := range accepted {
+		if version == current {
+			return version, nil
+		}
+	}
+	return 0, ErrVersionMismatch
+}
+
+// ReservationFilter narrows reservation listings. Empty fields match
+// everything. From and To, when both set, select reservations overlapping
+// [From, To).
+type ReservationFilter struct {
+	ChargerID string
+	// ChargerIDs matches reservations on any of the listed chargers.
+	ChargerIDs []string
+	UserID     string
+	SeriesID   string
+	States     []ReservationState
+	// From and To match reservations overlapping the window; either bound
+	// may be left open.
+	From time.Time
+	To   time.Time
+	// CreatedFrom and CreatedTo match reservations created in
+	// [CreatedFrom, CreatedTo); either bound may be left open.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// reservationTimeFields are the document keys that older versions of the
+// service stored as RFC3339 strings instead of BSON dates.
+var reservationTimeFields = map[string]bool{
+	"from":     true,
+	"to":       true,
+	"created":  true,
+	"modified": true,
+}
+
+// UnmarshalBSON decodes a reservation document, converting legacy string
+// timestamps to time.Time on the way. Documents written before reservations
+// had a lifecycle are reported as pending.
+func (r *Reservation) UnmarshalBSON(data []byte) error {
+	doc := bson.D{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	legacy := false
+	for i, elem := range doc {
+		str, ok := elem.Value.(string)
+		if !ok || !reservationTimeFields[elem.Key] {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return err
+		}
+		doc[i].Value = t
+		legacy = true
+	}
+	if legacy {
+		var err error
+		if data, err = bson.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	type plain Reservation
+	*r = Reservation{}
+	if err := bson.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	if r.State == "" {
+		r.State = StatePending
+	}
+	return nil
+}
+
+// newReservation returns a pending reservation of userID, created at now.
+func newReservation(userID primitive.ObjectID, chargerID primitive.ObjectID, from time.Time, to time.Time, now time.Time) Reservation {
+	return Reservation{
+		ChargerID: chargerID,
+		UserID:    userID,
+		From:      from,
+		To:        to,
+		State:     StatePending,
+		History:   []StateTransition{{To: StatePending, At: now, Actor: userID.Hex()}},
+		Version:   1,
+		Created:   now,
+		Modified:  now,
+	}
+}
+
+// overlaps reports whether the half-open windows [from1, to1) and [from2, to2)
+// intersect.
+func overlaps(from1 time.Time, to1 time.Time, from2 time.Time, to2 time.Time) bool {
+	return from1.Before(to2) && from2.Before(to1)
 }
 
 type ReservationDB interface {
-	CreateReservation(ctx context.Context, from string, to string, userID string, chargerID string) error
+	CreateReservation(ctx context.Context, from time.Time, to time.Time, userID string, chargerID string) error
 	GetReservation(ctx context.Context, id string) (Reservation, error)
-	GetReservations(ctx context.Context) ([]Reservation, error)
-	GetReservationsFilter(ctx context.Context, chargerID string, userID string) ([]Reservation, error)
-	UpdateReservation(ctx context.Context, id string, from string, to string) error
-	DeleteReservation(ctx context.Context, id string) error
-	ReservationClosest(ctx context.Context, userID, from string, to string, location Location) (Reservation, error)
+	GetReservations(ctx context.Context, states []ReservationState) ([]Reservation, error)
+	GetReservationsFilter(ctx context.Context, filter ReservationFilter) ([]Reservation, error)
+	GetReservationsPage(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error)
+	UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersion int64) error
+	UpdateReservationState(ctx context.Context, id string, expected ReservationState, transition StateTransition) (Reservation, error)
+	DeleteReservation(ctx context.Context, id string, expectedVersion int64) error
+	CreateSeries(ctx context.Context, series ReservationSeries, occurrences []Interval) (ReservationSeries, []OccurrenceResult, error)
+	MoveSeries(ctx context.Context, id string, shift time.Duration, length time.Duration) error
+	SplitSeries(ctx context.Context, id string, at time.Time, shift time.Duration, length time.Duration, occurrences []string) (ReservationSeries, error)
+	ReservationClosest(ctx context.Context, userID string, from time.Time, to time.Time, location Location) (Reservation, error)
+	FindChargers(ctx context.Context, from time.Time, to time.Time, location Location) ([]ChargerCandidate, error)
 }
@@ -0,0 +1,109 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReservationUnmarshalLegacyStrings(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"from":     "2022-01-10T08:00:00Z",
+		"to":       "2022-01-10T09:30:00Z",
+		"created":  "2022-01-01T12:00:00+01:00",
+		"modified": time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Reservation{}
+	if err := bson.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := time.Date(2022, 1, 10, 9, 30, 0, 0, time.UTC)
+	if !got.To.Equal(want) {
+		t.Fatalf("want to %v, got %v", want, got.To)
+	}
+	if got.Created.UTC().Hour() != 11 {
+		t.Fatalf("created parsed with wrong offset: %v", got.Created)
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		from, to time.Time
+		want     error
+	}{
+		{now.Add(time.Hour), now.Add(2 * time.Hour), nil},
+		{now.Add(2 * time.Hour), now.Add(time.Hour), ErrWindowReversed},
+		{now.Add(time.Hour), now.Add(time.Hour), ErrWindowReversed},
+		{now.Add(-time.Hour), now.Add(time.Hour), ErrWindowInPast},
+		{now.Add(time.Hour), now.Add(time.Hour + MaxReservationDuration + time.Minute), ErrWindowTooLong},
+	}
+	for _, c := range cases {
+		if got := validateWindow(c.from, c.to, now); got != c.want {
+			t.Errorf("validateWindow(%v, %v) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	if !CanTransition(StatePending, StateConfirmed) || !CanTransition(StateConfirmed, StateCheckedIn) || !CanTransition(StateCheckedIn, StateCompleted) {
+		t.Fatal("happy path must be allowed")
+	}
+	for _, to := range []ReservationState{StatePending, StateConfirmed, StateCheckedIn} {
+		if CanTransition(StateCancelled, to) {
+			t.Errorf("cancelled reservation moved to %s", to)
+		}
+	}
+	if CanTransition(StatePending, StateCompleted) {
+		t.Error("pending reservation completed without check-in")
+	}
+}
+
+func TestServiceUpdateRequiresReschedulableState(t *testing.T) {
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	user := primitive.NewObjectID()
+	ctx := NewContextWithPrincipal(context.Background(), Principal{UserID: user.Hex(), Roles: []Role{RoleUser}})
+	reservation := mustCreate(t, db, user, primitive.NewObjectID(), hour(0), hour(1))
+	id := reservation.ID.Hex()
+
+	for _, c := range []struct {
+		state       ReservationState
+		reschedules bool
+	}{
+		{StatePending, true},
+		{StateConfirmed, true},
+		{StateCheckedIn, false},
+		{StateCompleted, false},
+	} {
+		if c.state != StatePending {
+			current, err := db.GetReservation(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transition := StateTransition{From: current.State, To: c.state, At: time.Now(), Actor: user.Hex()}
+			if _, err := db.UpdateReservationState(context.Background(), id, current.State, transition); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := srv.UpdateReservation(ctx, id, hour(0), hour(2), nil)
+		if c.reschedules && err != nil {
+			t.Errorf("%s reservation not rescheduled: %v", c.state, err)
+		}
+		if !c.reschedules && !errors.Is(err, ErrNotReschedulable) {
+			t.Errorf("%s reservation: got %v, want ErrNotReschedulable", c.state, err)
+		}
+	}
+	if status, _ := errorStatus(ErrNotReschedulable); status != http.StatusConflict {
+		t.Errorf("got status %d, want 409", status)
+	}
+}
@@ -0,0 +1,206 @@
+package reservations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxOccurrences caps how many occurrences a single recurrence may expand to.
+const MaxOccurrences = 366
+
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+// RecurrenceRule is the subset of an iCalendar RRULE (RFC 5545) supported
+// for recurring reservations: FREQ, INTERVAL, BYDAY, UNTIL and COUNT.
+type RecurrenceRule struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	Until    time.Time
+	Count    int
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// ParseRRule parses a rule such as "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=20".
+// A leading "RRULE:" is accepted. Either UNTIL or COUNT must be present so the
+// rule always expands to a finite set.
+func ParseRRule(s string) (RecurrenceRule, error) {
+	rule := RecurrenceRule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("rrule: malformed part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), kv[1]
+		switch key {
+		case "FREQ":
+			switch value = strings.ToUpper(value); value {
+			case FreqDaily, FreqWeekly, FreqMonthly:
+				rule.Freq = value
+			default:
+				return rule, fmt.Errorf("rrule: unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("rrule: invalid INTERVAL %q", value)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("rrule: invalid COUNT %q", value)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseRRuleTime(value)
+			if err != nil {
+				return rule, fmt.Errorf("rrule: invalid UNTIL %q", value)
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(value), ",") {
+				weekday, ok := rruleWeekdays[day]
+				if !ok {
+					return rule, fmt.Errorf("rrule: unsupported BYDAY value %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		default:
+			return rule, fmt.Errorf("rrule: unsupported part %q", key)
+		}
+	}
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("rrule: FREQ is required")
+	}
+	if rule.Count == 0 && rule.Until.IsZero() {
+		return rule, fmt.Errorf("rrule: COUNT or UNTIL is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, fmt.Errorf("rrule: COUNT and UNTIL are mutually exclusive")
+	}
+	if rule.Freq == FreqMonthly && len(rule.ByDay) > 0 {
+		return rule, fmt.Errorf("rrule: BYDAY is not supported with FREQ=MONTHLY")
+	}
+	return rule, nil
+}
+
+// String formats rule so that ParseRRule reads it back.
+func (rule RecurrenceRule) String() string {
+	parts := []string{"FREQ=" + rule.Freq}
+	if rule.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rule.Interval))
+	}
+	if len(rule.ByDay) > 0 {
+		days := []string{}
+		for _, day := range rule.ByDay {
+			days = append(days, strings.ToUpper(day.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if rule.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rule.Count))
+	} else {
+		parts = append(parts, "UNTIL="+rule.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+func parseRRuleTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", s)
+}
+
+// Expand returns the start times of the occurrences of rule, the first being
+// start itself when it matches the rule. Expansion stops at COUNT, UNTIL or
+// MaxOccurrences, whichever comes first.
+func (rule RecurrenceRule) Expand(start time.Time) []time.Time {
+	limit := MaxOccurrences
+	if rule.Count > 0 && rule.Count < limit {
+		limit = rule.Count
+	}
+	occurrences := []time.Time{}
+	add := func(t time.Time) bool {
+		if !rule.Until.IsZero() && t.After(rule.Until) {
+			return false
+		}
+		occurrences = append(occurrences, t)
+		return len(occurrences) < limit
+	}
+	// Bound the number of periods scanned so sparse rules cannot loop forever.
+	for period := 0; period < MaxOccurrences*7; period++ {
+		for _, t := range rule.period(start, period) {
+			if t.Before(start) {
+				continue
+			}
+			if !add(t) {
+				return occurrences
+			}
+		}
+	}
+	return occurrences
+}
+
+// period lists the candidate occurrences in the n-th period of the rule.
+func (rule RecurrenceRule) period(start time.Time, n int) []time.Time {
+	step := n * rule.Interval
+	switch rule.Freq {
+	case FreqDaily:
+		t := start.AddDate(0, 0, step)
+		if len(rule.ByDay) > 0 && !rule.hasWeekday(t.Weekday()) {
+			return nil
+		}
+		return []time.Time{t}
+	case FreqWeekly:
+		// Weeks start on Monday, the RFC 5545 default WKST.
+		weekStart := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*step)
+		days := rule.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		times := []time.Time{}
+		for _, day := range days {
+			times = append(times, weekStart.AddDate(0, 0, (int(day)+6)%7))
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		return times
+	case FreqMonthly:
+		t := start.AddDate(0, step, 0)
+		if t.Day() != start.Day() {
+			// The month is too short for this day, so RFC 5545 skips it.
+			return nil
+		}
+		return []time.Time{t}
+	}
+	return nil
+}
+
+func (rule RecurrenceRule) hasWeekday(day time.Weekday) bool {
+	for _, d := range rule.ByDay {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package reservations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceRuleExpandWeekdays(t *testing.T) {
+	rule, err := ParseRRule("RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wednesday morning.
+	start := time.Date(2022, 1, 12, 7, 30, 0, 0, time.UTC)
+	got := rule.Expand(start)
+	want := []int{12, 13, 14, 17, 18, 19, 20}
+	if len(got) != len(want) {
+		t.Fatalf("want %d occurrences, got %d: %v", len(want), len(got), got)
+	}
+	for i, day := range want {
+		if got[i].Day() != day || got[i].Hour() != 7 || got[i].Minute() != 30 {
+			t.Errorf("occurrence %d = %v, want Jan %d 07:30", i, got[i], day)
+		}
+	}
+}
+
+func TestRecurrenceRuleExpandUntil(t *testing.T) {
+	rule, err := ParseRRule("FREQ=DAILY;INTERVAL=2;UNTIL=20220110T000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rule.Expand(time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC))
+	if len(got) != 5 || got[4].Day() != 9 {
+		t.Fatalf("unexpected occurrences %v", got)
+	}
+}
+
+func TestParseRRuleRejectsUnbounded(t *testing.T) {
+	if _, err := ParseRRule("FREQ=DAILY"); err == nil {
+		t.Fatal("expected unbounded rule to be rejected")
+	}
+}
+
+func TestRecurrenceRuleString(t *testing.T) {
+	for _, text := range []string{
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10",
+		"FREQ=DAILY;UNTIL=20300101T100000Z",
+	} {
+		rule, err := ParseRRule(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.String() != text {
+			t.Errorf("%q formats as %q", text, rule.String())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package reservations
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	DefaultSearchLimit = 5
+	MaxSearchLimit     = 50
+)
+
+// ChargerCandidate is a charger considered by the closest charger search.
+type ChargerCandidate struct {
+	ChargerID     string   `json:"chargerID"`
+	Name          string   `json:"name"`
+	Location      Location `json:"location"`
+	Distance      float64  `json:"distance"`
+	Available     bool     `json:"available"`
+	AverageRating float64  `json:"averageRating"`
+	Score         float64  `json:"score"`
+	Rank          int      `json:"rank"`
+	Reasons       []string `json:"reasons"`
+}
+
+// Scorer rates a search candidate. Higher scores rank first; the returned
+// reasons explain how the score came about.
+type Scorer interface {
+	Score(candidate ChargerCandidate) (float64, []string)
+}
+
+// WeightedScorer blends closeness and rating. Closeness falls linearly from 1
+// at the search location to 0 at MaxDistance kilometres, the rating is
+// normalised to the 0-5 star scale.
+type WeightedScorer struct {
+	DistanceWeight float64
+	RatingWeight   float64
+	MaxDistance    float64
+}
+
+// DefaultScorer favours distance over rating.
+var DefaultScorer = WeightedScorer{
+	DistanceWeight: 0.7,
+	RatingWeight:   0.3,
+	MaxDistance:    DefaultMaxSearchRadius,
+}
+
+func (w WeightedScorer) Score(candidate ChargerCandidate) (float64, []string) {
+	closeness := 0.0
+	if w.MaxDistance > 0 && candidate.Distance < w.MaxDistance {
+		closeness = 1 - candidate.Distance/w.MaxDistance
+	}
+	rating := candidate.AverageRating / 5
+	distanceScore := w.DistanceWeight * closeness
+	ratingScore := w.RatingWeight * rating
+	return distanceScore + ratingScore, []string{
+		fmt.Sprintf("distance %.2f km adds %.3f (weight %.2f)", candidate.Distance, distanceScore, w.DistanceWeight),
+		fmt.Sprintf("rating %.1f/5 adds %.3f (weight %.2f)", candidate.AverageRating, ratingScore, w.RatingWeight),
+	}
+}
+
+// rankCandidates scores candidates with scorer and returns the best limit of
+// them. Chargers that are busy for the window always rank after free ones.
+func rankCandidates(candidates []ChargerCandidate, scorer Scorer, limit int) []ChargerCandidate {
+	for i := range candidates {
+		candidates[i].Score, candidates[i].Reasons = scorer.Score(candidates[i])
+		if !candidates[i].Available {
+			candidates[i].Reasons = append(candidates[i].Reasons, "already reserved for this window, ranked after available chargers")
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Available != candidates[j].Available {
+			return candidates[i].Available
+		}
+		return candidates[i].Score > candidates[j].Score
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	for i := range candidates {
+		candidates[i].Rank = i + 1
+	}
+	return candidates
+}
@@ -0,0 +1,25 @@
+package reservations
+
+import "testing"
+
+func TestRankCandidates(t *testing.T) {
+	candidates := []ChargerCandidate{
+		{ChargerID: "near-busy", Distance: 1, AverageRating: 5, Available: false},
+		{ChargerID: "far-good", Distance: 20, AverageRating: 5, Available: true},
+		{ChargerID: "near-poor", Distance: 2, AverageRating: 1, Available: true},
+		{ChargerID: "far-poor", Distance: 40, AverageRating: 1, Available: true},
+	}
+	got := rankCandidates(candidates, WeightedScorer{DistanceWeight: 0.5, RatingWeight: 0.5, MaxDistance: 50}, 3)
+	want := []string{"far-good", "near-poor", "far-poor"}
+	if len(got) != len(want) {
+		t.Fatalf("want %d candidates, got %d", len(want), len(got))
+	}
+	for i, id := range want {
+		if got[i].ChargerID != id || got[i].Rank != i+1 {
+			t.Errorf("rank %d: want %s, got %s (rank %d)", i+1, id, got[i].ChargerID, got[i].Rank)
+		}
+		if len(got[i].Reasons) == 0 {
+			t.Errorf("%s has no ranking reasons", got[i].ChargerID)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package reservations
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SeriesScope selects which occurrences of a recurring reservation an edit
+// or cancellation applies to.
+type SeriesScope string
+
+const (
+	ScopeThis      SeriesScope = "this"
+	ScopeFollowing SeriesScope = "following"
+	ScopeAll       SeriesScope = "all"
+)
+
+// Occurrence outcomes reported back to the client.
+const (
+	OccurrenceBooked    = "booked"
+	OccurrenceUpdated   = "updated"
+	OccurrenceCancelled = "cancelled"
+	OccurrenceConflict  = "conflict"
+	OccurrenceFailed    = "failed"
+)
+
+// ReservationSeries is a recurring reservation. Its occurrences are stored
+// as ordinary reservations carrying the series ID.
+type ReservationSeries struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ChargerID primitive.ObjectID `json:"chargerID"`
+	UserID    primitive.ObjectID `json:"userID"`
+	RRule     string             `json:"rrule"`
+	From      time.Time          `json:"from"`
+	To        time.Time          `json:"to"`
+	Created   time.Time          `json:"created"`
+}
+
+// OccurrenceResult reports what happened to a single occurrence of a series.
+type OccurrenceResult struct {
+	ReservationID string    `json:"reservationID,omitempty"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// ParseSeriesScope validates s as a series scope. An empty string means
+// ScopeThis.
+func ParseSeriesScope(s string) (SeriesScope, error) {
+	switch scope := SeriesScope(s); scope {
+	case "":
+		return ScopeThis, nil
+	case ScopeThis, ScopeFollowing, ScopeAll:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown series scope %q", s)
+}
+
+// expandSeries lists the occurrence windows of a series whose first
+// occurrence is [from, to).
+func expandSeries(rule RecurrenceRule, from time.Time, to time.Time) []Interval {
+	duration := to.Sub(from)
+	occurrences := []Interval{}
+	for _, start := range rule.Expand(from) {
+		occurrences = append(occurrences, Interval{From: start, To: start.Add(duration)})
+	}
+	return occurrences
+}
+
+// seriesTargets picks the occurrences of a series affected by an edit of
+// current with the given scope. Occurrences that already started are left
+// alone.
+func seriesTargets(current Reservation, occurrences []Reservation, scope SeriesScope, now time.Time) []Reservation {
+	if scope == ScopeThis || current.SeriesID.IsZero() {
+		return []Reservation{current}
+	}
+	targets := []Reservation{}
+	for _, occurrence := range occurrences {
+		if !occurrence.From.After(now) {
+			continue
+		}
+		if scope == ScopeFollowing && occurrence.From.Before(current.From) {
+			continue
+		}
+		targets = append(targets, occurrence)
+	}
+	return targets
+}
+
+// splitSeries ends series just before its occurrence starting at and
+// returns it with the series taking over the occurrences from there on,
+// moved by shift and lasting length. The new series has no ID yet.
+func splitSeries(series ReservationSeries, at time.Time, shift time.Duration, length time.Duration) (ReservationSeries, ReservationSeries, error) {
+	rule, err := ParseRRule(series.RRule)
+	if err != nil {
+		return series, ReservationSeries{}, err
+	}
+	following := rule
+	if rule.Count > 0 {
+		following.Count = 0
+		for _, start := range rule.Expand(series.From) {
+			if !start.Before(at) {
+				following.Count++
+			}
+		}
+		if following.Count == 0 {
+			following.Count = 1
+		}
+	} else {
+		following.Until = rule.Until.Add(shift)
+	}
+	// Moving an occurrence to another weekday moves every weekday of the
+	// rule along with it.
+	days := (int(at.Add(shift).Weekday()) - int(at.Weekday()) + 7) % 7
+	following.ByDay = nil
+	for _, day := range rule.ByDay {
+		following.ByDay = append(following.ByDay, time.Weekday((int(day)+days)%7))
+	}
+	ended := rule
+	ended.Count = 0
+	ended.Until = at.Add(-time.Second)
+	series.RRule = ended.String()
+	return series, ReservationSeries{
+		ChargerID: series.ChargerID,
+		UserID:    series.UserID,
+		RRule:     following.String(),
+		From:      at.Add(shift),
+		To:        at.Add(shift + length),
+		Created:   time.Now().UTC(),
+	}, nil
+}
+
+func seriesIDHex(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
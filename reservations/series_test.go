@@ -0,0 +1,135 @@
+package reservations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestServiceUpdateSeriesShiftsDaily(t *testing.T) {
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	ctx := NewContextWithPrincipal(context.Background(), Principal{UserID: primitive.NewObjectID().Hex()})
+	charger := primitive.NewObjectID().Hex()
+
+	series, _, _, err := srv.CreateRecurringReservation(ctx, charger, hour(0), hour(1), "FREQ=DAILY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := mustOccurrence(t, db, series.ID, hour(0))
+
+	for _, shift := range []int{24, -24} {
+		start := first.From.Add(time.Duration(shift) * time.Hour)
+		results, err := srv.UpdateSeries(ctx, first.ID.Hex(), start, start.Add(time.Hour), ScopeAll)
+		if err != nil {
+			t.Fatalf("shifting by %dh: %v", shift, err)
+		}
+		for i, result := range results {
+			want := start.Add(time.Duration(24*i) * time.Hour)
+			if result.Status != OccurrenceUpdated || !result.From.Equal(want) {
+				t.Errorf("shifting by %dh: occurrence %d got %+v, want updated at %v", shift, i, result, want)
+			}
+		}
+		if len(results) != 3 {
+			t.Errorf("shifting by %dh: got %d results", shift, len(results))
+		}
+		stored := db.(*memoryDatabase).series[series.ID]
+		if !stored.From.Equal(start) || !stored.To.Equal(start.Add(time.Hour)) {
+			t.Errorf("shifting by %dh: series window is %v-%v", shift, stored.From, stored.To)
+		}
+		first = mustOccurrence(t, db, series.ID, start)
+	}
+}
+
+func TestServiceUpdateSeriesFollowingSplits(t *testing.T) {
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	ctx := NewContextWithPrincipal(context.Background(), Principal{UserID: primitive.NewObjectID().Hex()})
+
+	series, _, _, err := srv.CreateRecurringReservation(ctx, primitive.NewObjectID().Hex(), hour(0), hour(1), "FREQ=DAILY;COUNT=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	third := mustOccurrence(t, db, series.ID, hour(48))
+	results, err := srv.UpdateSeries(ctx, third.ID.Hex(), hour(50), hour(51), ScopeFollowing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != OccurrenceUpdated || results[1].Status != OccurrenceUpdated {
+		t.Fatalf("got results %+v", results)
+	}
+
+	mem := db.(*memoryDatabase)
+	ended := mem.series[series.ID]
+	rule, err := ParseRRule(ended.RRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if occurrences := expandSeries(rule, ended.From, ended.To); len(occurrences) != 2 {
+		t.Errorf("original series %q still expands to %v", ended.RRule, occurrences)
+	}
+	if found, _ := db.GetReservationsFilter(ctx, ReservationFilter{SeriesID: series.ID.Hex()}); len(found) != 2 {
+		t.Errorf("original series keeps %d occurrences, want 2", len(found))
+	}
+
+	moved, err := db.GetReservation(ctx, results[0].ReservationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	following, ok := mem.series[moved.SeriesID]
+	if moved.SeriesID == series.ID || !ok {
+		t.Fatalf("moved occurrence is in series %s", moved.SeriesID.Hex())
+	}
+	rule, err = ParseRRule(following.RRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences := expandSeries(rule, following.From, following.To)
+	if len(occurrences) != 2 || !occurrences[0].From.Equal(hour(50)) || !occurrences[1].From.Equal(hour(74)) {
+		t.Errorf("new series %q from %v expands to %v", following.RRule, following.From, occurrences)
+	}
+	mustOccurrence(t, db, following.ID, hour(74))
+}
+
+func TestServiceUpdateSeriesAllKeepsSeriesOnConflict(t *testing.T) {
+	db := NewMemoryDatabase(log.NewNopLogger(), &ChargerIndex{}, conformanceRadius)
+	srv := NewService(db, log.NewNopLogger(), DefaultScorer, RolePolicy{}, nil)
+	ctx := NewContextWithPrincipal(context.Background(), Principal{UserID: primitive.NewObjectID().Hex()})
+	charger := primitive.NewObjectID()
+
+	series, _, _, err := srv.CreateRecurringReservation(ctx, charger.Hex(), hour(0), hour(1), "FREQ=DAILY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreate(t, db, primitive.NewObjectID(), charger, hour(25), hour(26))
+	first := mustOccurrence(t, db, series.ID, hour(0))
+	results, err := srv.UpdateSeries(ctx, first.ID.Hex(), hour(1), hour(2), ScopeAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[1].Status != OccurrenceConflict {
+		t.Fatalf("got results %+v", results)
+	}
+	if stored := db.(*memoryDatabase).series[series.ID]; !stored.From.Equal(hour(0)) {
+		t.Errorf("series moved to %v although an occurrence did not", stored.From)
+	}
+}
+
+// mustOccurrence returns the occurrence of series starting at from.
+func mustOccurrence(t *testing.T, db ReservationDB, series primitive.ObjectID, from time.Time) Reservation {
+	t.Helper()
+	found, err := db.GetReservationsFilter(context.Background(), ReservationFilter{SeriesID: series.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range found {
+		if r.From.Equal(from) {
+			return r
+		}
+	}
+	t.Fatalf("series %s has no occurrence at %v", series.Hex(), from)
+	return Reservation{}
+}
@@ -4,50 +4,116 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/go-kit/kit/endpoint"
 	ht "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 )
 
-func NewHttpServer(ctx context.Context, endpoints Endpoints) http.Handler {
-	r := mux.NewRouter()
-	r.Use(commonMiddleware)
+// NewHttpServer routes the reservation API to endpoints. middlewares wrap
+// every route but the health check.
+func NewHttpServer(ctx context.Context, endpoints Endpoints, health *Health, middlewares ...mux.MiddlewareFunc) http.Handler {
+	root := mux.NewRouter()
+	root.Use(commonMiddleware)
 
+	root.Methods("GET").Path("/health").Handler(health)
+
+	r := root.NewRoute().Subrouter()
+	r.Use(middlewares...)
+
+	options := []ht.ServerOption{
+		ht.ServerBefore(ht.PopulateRequestContext),
+		ht.ServerErrorEncoder(encodeError),
+	}
+	idempotent := append(options, ht.ServerBefore(populateIdempotencyKey))
 	r.Methods("POST").Path("/reservations").Handler(ht.NewServer(
 		endpoints.CreateReservation,
 		decodeCreateReservationRequest,
 		encodeResponse,
+		idempotent...,
 	))
 	r.Methods("POST").Path("/reservations/closest").Handler(ht.NewServer(
 		endpoints.ReservationClosest,
 		decodeReservationClosestRequest,
 		encodeResponse,
+		idempotent...,
+	))
+	r.Methods("POST").Path("/reservations/closest/search").Handler(ht.NewServer(
+		endpoints.SearchChargers,
+		decodeSearchChargersRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("POST").Path("/reservations/recurring").Handler(ht.NewServer(
+		endpoints.CreateRecurring,
+		decodeCreateRecurringRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("PUT").Path("/reservations/{id}/series").Handler(ht.NewServer(
+		endpoints.UpdateSeries,
+		decodeUpdateSeriesRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("POST").Path("/reservations/{id}/series/cancel").Handler(ht.NewServer(
+		endpoints.CancelSeries,
+		decodeCancelSeriesRequest,
+		encodeResponse,
+		options...,
 	))
 	r.Methods("PUT").Path("/reservations/{id}").Handler(ht.NewServer(
 		endpoints.UpdateReservation,
 		decodeUpdateReservationRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("GET").Path("/reservations/{id}").Handler(ht.NewServer(
 		endpoints.GetReservation,
 		decodeGetReservationRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("GET").Path("/reservations").Handler(ht.NewServer(
 		endpoints.GetReservations,
 		decodeGetReservationsRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("GET").Path("/reservations/").Handler(ht.NewServer(
 		endpoints.GetReservationsFilter,
 		decodeGetReservationsFilterRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("DELETE").Path("/reservations/{id}").Handler(ht.NewServer(
 		endpoints.DeleteReservation,
 		decodeDeleteReservationRequest,
 		encodeResponse,
+		options...,
+	))
+	r.Methods("GET").Path("/chargers/{id}/availability").Handler(ht.NewServer(
+		endpoints.GetAvailability,
+		decodeGetAvailabilityRequest,
+		encodeResponse,
+		options...,
 	))
-	return r
+	transitions := map[string]endpoint.Endpoint{
+		"confirm":  endpoints.ConfirmReservation,
+		"check-in": endpoints.CheckInReservation,
+		"complete": endpoints.CompleteReservation,
+		"cancel":   endpoints.CancelReservation,
+		"no-show":  endpoints.NoShowReservation,
+		"expire":   endpoints.ExpireReservation,
+	}
+	for action, e := range transitions {
+		r.Methods("POST").Path("/reservations/{id}/" + action).Handler(ht.NewServer(
+			e,
+			decodeTransitionReservationRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	return root
 }
 
 func commonMiddleware(next http.Handler) http.Handler {
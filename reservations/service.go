@@ -2,14 +2,21 @@ package reservations
 
 import (
 	"context"
+	"time"
 )
 
 type ReservationsService interface {
-	CreateReservation(ctx context.Context, from string, to string, userToken string, chargerID string) (string, error)
+	CreateReservation(ctx context.Context, from time.Time, to time.Time, chargerID string) (string, error)
 	GetReservation(ctx context.Context, id string) (Reservation, error)
-	GetReservations(ctx context.Context) ([]Reservation, error)
-	GetReservationsFilter(ctx context.Context, chargerID string, userID string) ([]Reservation, error)
-	UpdateReservation(ctx context.Context, id string, from string, to string) (string, error)
-	DeleteReservation(ctx context.Context, id string) (string, error)
-	ReservationClosest(ctx context.Context, userToken string, from string, to string, location Location) (Reservation, string, error)
+	GetReservations(ctx context.Context, states []ReservationState, page PageRequest) (ReservationPage, error)
+	GetReservationsFilter(ctx context.Context, filter ReservationFilter, page PageRequest) (ReservationPage, error)
+	UpdateReservation(ctx context.Context, id string, from time.Time, to time.Time, expectedVersions []int64) (string, error)
+	DeleteReservation(ctx context.Context, id string, expectedVersions []int64) (string, error)
+	ReservationClosest(ctx context.Context, from time.Time, to time.Time, location Location) (Reservation, string, error)
+	SearchChargers(ctx context.Context, from time.Time, to time.Time, location Location, limit int) ([]ChargerCandidate, error)
+	TransitionReservation(ctx context.Context, id string, to ReservationState) (Reservation, string, error)
+	GetAvailability(ctx context.Context, chargerID string, from time.Time, to time.Time, slot time.Duration) (Availability, error)
+	CreateRecurringReservation(ctx context.Context, chargerID string, from time.Time, to time.Time, rrule string) (ReservationSeries, []OccurrenceResult, string, error)
+	UpdateSeries(ctx context.Context, id string, from time.Time, to time.Time, scope SeriesScope) ([]OccurrenceResult, error)
+	CancelSeries(ctx context.Context, id string, scope SeriesScope) ([]OccurrenceResult, string, error)
 }
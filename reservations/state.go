@@ -0,0 +1,81 @@
+package reservations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ReservationState string
+
+const (
+	StatePending   ReservationState = "pending"
+	StateConfirmed ReservationState = "confirmed"
+	StateCheckedIn ReservationState = "checked-in"
+	StateCompleted ReservationState = "completed"
+	StateCancelled ReservationState = "cancelled"
+	StateNoShow    ReservationState = "no-show"
+	StateExpired   ReservationState = "expired"
+)
+
+// transitions lists, for every state, the states a reservation may move to
+// next. Terminal states have no entry.
+var transitions = map[ReservationState][]ReservationState{
+	StatePending:   {StateConfirmed, StateCancelled, StateExpired},
+	StateConfirmed: {StateCheckedIn, StateCancelled, StateNoShow},
+	StateCheckedIn: {StateCompleted},
+}
+
+// releasedStates are the states in which a reservation no longer holds its
+// charger, so other bookings may overlap it.
+var releasedStates = []ReservationState{StateCompleted, StateCancelled, StateNoShow, StateExpired}
+
+// StateTransition records a single lifecycle change of a reservation.
+type StateTransition struct {
+	From  ReservationState `json:"from,omitempty" bson:"from,omitempty"`
+	To    ReservationState `json:"to" bson:"to"`
+	At    time.Time        `json:"at" bson:"at"`
+	Actor string           `json:"actor" bson:"actor"`
+}
+
+// CanTransition reports whether a reservation in state from may move to state to.
+func CanTransition(from ReservationState, to ReservationState) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReschedule reports whether a reservation in state may still be moved
+// to another window.
+func CanReschedule(state ReservationState) bool {
+	return state == StatePending || state == StateConfirmed
+}
+
+// ParseReservationState validates s as a known reservation state.
+func ParseReservationState(s string) (ReservationState, error) {
+	switch state := ReservationState(s); state {
+	case StatePending, StateConfirmed, StateCheckedIn, StateCompleted, StateCancelled, StateNoShow, StateExpired:
+		return state, nil
+	}
+	return "", fmt.Errorf("unknown reservation state %q", s)
+}
+
+// parseStates parses a comma separated list of states, as used by the
+// listing endpoints. An empty string yields no states.
+func parseStates(s string) ([]ReservationState, error) {
+	if s == "" {
+		return nil, nil
+	}
+	states := []ReservationState{}
+	for _, part := range strings.Split(s, ",") {
+		state, err := ParseReservationState(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,265 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Violation describes one invalid input. Field is the JSON path of the value,
+// such as "location.latitude", or the name of the query parameter or header
+// it came from.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError carries every violation found in a request. It is an
+// ErrInvalidArgument.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.Field+": "+v.Message)
+	}
+	return "invalid request: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalidArgument }
+
+// validator is implemented by requests that can check their own fields.
+type validator interface {
+	Validate() error
+}
+
+// MakeValidationMiddleware rejects requests whose Validate method reports
+// violations before they reach the service.
+func MakeValidationMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if v, ok := request.(validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// violations collects problems while a request is checked.
+type violations []Violation
+
+func (v *violations) add(field string, format string, args ...interface{}) {
+	*v = append(*v, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v violations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v}
+}
+
+func (v *violations) id(field string, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(field, "is required")
+		}
+		return
+	}
+	if !primitive.IsValidObjectID(value) {
+		v.add(field, "must be a 24 character hex id")
+	}
+}
+
+// window checks that from and to are set, ordered and at most max apart.
+func (v *violations) window(from time.Time, to time.Time, max time.Duration) {
+	if from.IsZero() {
+		v.add("from", "is required")
+	}
+	if to.IsZero() {
+		v.add("to", "is required")
+	}
+	if from.IsZero() || to.IsZero() {
+		return
+	}
+	if !from.Before(to) {
+		v.add("to", "must be after from")
+	} else if to.Sub(from) > max {
+		v.add("to", "must be at most %s after from", max)
+	}
+}
+
+// order checks that two optional bounds, when both are set, are ordered.
+func (v *violations) order(fromField string, from time.Time, toField string, to time.Time) {
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		v.add(toField, "must be after %s", fromField)
+	}
+}
+
+func (v *violations) page(page PageRequest) {
+	if page.Limit < 0 || page.Limit > MaxPageSize {
+		v.add("limit", "must be between 1 and %d", MaxPageSize)
+	}
+	if _, err := page.normalize().cursor(); err != nil {
+		v.add("cursor", "%v", err)
+	}
+}
+
+func (v *violations) location(field string, location *Location) {
+	if location == nil {
+		v.add(field, "is required")
+		return
+	}
+	if location.Latitude < -90 || location.Latitude > 90 {
+		v.add(field+".latitude", "must be between -90 and 90")
+	}
+	if location.Longitude < -180 || location.Longitude > 180 {
+		v.add(field+".longitude", "must be between -180 and 180")
+	}
+}
+
+// fieldError reports err, usually a parse failure, as a violation of field.
+func fieldError(field string, err error) error {
+	return &ValidationError{Violations: []Violation{{Field: field, Message: err.Error()}}}
+}
+
+// decodeJSON strictly decodes the request body into v. Unknown fields and
+// values of the wrong JSON type are reported against the field concerned.
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case err == io.EOF:
+		return fieldError("body", errors.New("is required"))
+	case errors.As(err, &typeErr):
+		return fieldError(typeErr.Field, fmt.Errorf("must not be a JSON %s", typeErr.Value))
+	case errors.As(err, &timeErr):
+		return fieldError("body", fmt.Errorf("timestamp %s is not RFC 3339", timeErr.Value))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return fieldError(field, errors.New("is not a known field"))
+	}
+	return fieldError("body", err)
+}
+
+func (r CreateReservationRequest) Validate() error {
+	v := violations{}
+	v.id("chargerID", r.ChargerID, true)
+	v.window(r.From, r.To, MaxReservationDuration)
+	return v.err()
+}
+
+func (r GetReservationRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	return v.err()
+}
+
+func (r UpdateReservationRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	v.window(r.From, r.To, MaxReservationDuration)
+	return v.err()
+}
+
+func (r DeleteReservationRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	return v.err()
+}
+
+func (r GetReservationsRequest) Validate() error {
+	v := violations{}
+	v.page(r.Page)
+	return v.err()
+}
+
+func (r GetReservationsFilterRequest) Validate() error {
+	v := violations{}
+	v.id("charger", r.ChargerID, false)
+	for _, id := range r.ChargerIDs {
+		v.id("charger", id, true)
+	}
+	v.id("user", r.UserID, false)
+	v.order("from", r.From, "to", r.To)
+	v.order("createdFrom", r.CreatedFrom, "createdTo", r.CreatedTo)
+	v.page(r.Page)
+	return v.err()
+}
+
+func (r ReservationClosestRequest) Validate() error {
+	v := violations{}
+	v.window(r.From, r.To, MaxReservationDuration)
+	v.location("location", r.Location)
+	return v.err()
+}
+
+func (r SearchChargersRequest) Validate() error {
+	v := violations{}
+	v.window(r.From, r.To, MaxReservationDuration)
+	v.location("location", r.Location)
+	if r.Limit < 0 {
+		v.add("limit", "must not be negative")
+	}
+	return v.err()
+}
+
+func (r TransitionReservationRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	return v.err()
+}
+
+func (r GetAvailabilityRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.ChargerID, true)
+	v.window(r.From, r.To, MaxAvailabilityRange)
+	if r.Slot < 0 {
+		v.add("slot", "must not be negative")
+	}
+	return v.err()
+}
+
+func (r CreateRecurringRequest) Validate() error {
+	v := violations{}
+	v.id("chargerID", r.ChargerID, true)
+	v.window(r.From, r.To, MaxReservationDuration)
+	if r.RRule == "" {
+		v.add("rrule", "is required")
+	} else if _, err := ParseRRule(r.RRule); err != nil {
+		v.add("rrule", "%v", err)
+	}
+	return v.err()
+}
+
+func (r UpdateSeriesRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	v.window(r.From, r.To, MaxReservationDuration)
+	return v.err()
+}
+
+func (r CancelSeriesRequest) Validate() error {
+	v := violations{}
+	v.id("id", r.Id, true)
+	return v.err()
+}
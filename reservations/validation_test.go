@@ -0,0 +1,113 @@
+package reservations
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func violationFields(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("want a ValidationError, got %v", err)
+	}
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validation errors must be invalid arguments")
+	}
+	fields := []string{}
+	for _, v := range invalid.Violations {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+func TestRequestValidate(t *testing.T) {
+	from := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)
+	valid := ReservationClosestRequest{From: from, To: from.Add(time.Hour), Location: &Location{Latitude: 46.05, Longitude: 14.5}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+
+	err := ReservationClosestRequest{From: from, To: from.Add(-time.Hour), Location: &Location{Latitude: 500, Longitude: 14.5}}.Validate()
+	if got, want := violationFields(t, err), []string{"to", "location.latitude"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+
+	err = ReservationClosestRequest{From: from, To: from.Add(time.Hour)}.Validate()
+	if got, want := violationFields(t, err), []string{"location"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("closest without a location: got fields %v, want %v", got, want)
+	}
+	err = SearchChargersRequest{From: from, To: from.Add(time.Hour)}.Validate()
+	if got, want := violationFields(t, err), []string{"location"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("search without a location: got fields %v, want %v", got, want)
+	}
+
+	err = CreateReservationRequest{ChargerID: "not-an-id"}.Validate()
+	if got, want := violationFields(t, err), []string{"chargerID", "from", "to"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+
+	err = CreateRecurringRequest{ChargerID: "5f8f8c44b54764421b7156c1", From: from, To: from.Add(25 * time.Hour), RRule: "FREQ=HOURLY"}.Validate()
+	if got, want := violationFields(t, err), []string{"to", "rrule"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+}
+
+func TestDecodeJSONStrict(t *testing.T) {
+	cases := map[string]string{
+		`{"chargerID": "5f8f8c44b54764421b7156c1", "user": "x"}`: "user",
+		`{"chargerID": 12}`:    "chargerID",
+		`{"from": "tomorrow"}`: "body",
+		``:                     "body",
+	}
+	for body, field := range cases {
+		r := httptest.NewRequest("POST", "/reservations", strings.NewReader(body))
+		err := decodeJSON(r, &CreateReservationRequest{})
+		if got := violationFields(t, err); len(got) != 1 || got[0] != field {
+			t.Errorf("%s: got fields %v, want %s", body, got, field)
+		}
+	}
+}
+
+func TestParsePageRequestLimit(t *testing.T) {
+	for query, valid := range map[string]bool{"": true, "limit=1": true, "limit=0": false, "limit=-3": false, "limit=x": false} {
+		r := httptest.NewRequest("GET", "/reservations?"+query, nil)
+		v := violations{}
+		page := parsePageRequest(r.URL.Query(), &v)
+		v.page(page)
+		if (len(v) == 0) != valid {
+			t.Errorf("%q: got violations %v", query, v)
+		}
+	}
+}
+
+func TestDecodeUpdateSeriesScope(t *testing.T) {
+	body := `{"from":"2030-01-01T10:00:00Z","to":"2030-01-01T11:00:00Z"`
+	cases := []struct {
+		query, body string
+		want        SeriesScope
+	}{
+		{"", body + `}`, ScopeThis},
+		{"?scope=all", body + `}`, ScopeAll},
+		{"", body + `,"scope":"following"}`, ScopeFollowing},
+		{"?scope=all", body + `,"scope":"all"}`, ScopeAll},
+		{"?scope=all", body + `,"scope":"this"}`, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/reservations/5f8f8c44b54764421b7156c1/series"+c.query, strings.NewReader(c.body))
+		req, err := decodeUpdateSeriesRequest(r.Context(), r)
+		if c.want == "" {
+			if got := violationFields(t, err); len(got) != 1 || got[0] != "scope" {
+				t.Errorf("%s %s: got fields %v", c.query, c.body, got)
+			}
+			continue
+		}
+		if err != nil || req.(UpdateSeriesRequest).Scope != c.want {
+			t.Errorf("%s %s: got %+v, %v, want scope %s", c.query, c.body, req, err, c.want)
+		}
+	}
+}